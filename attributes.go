@@ -0,0 +1,52 @@
+package mathjax
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark/util"
+)
+
+type withAttributeLists struct {
+	value bool
+}
+
+// WithAttributeLists allows an attribute list such as `{#eq1 .important}`
+// after the closing fence of a display block. Its id and classes are added
+// to the math span.
+func WithAttributeLists(value bool) Option {
+	return &withAttributeLists{value}
+}
+
+func (o *withAttributeLists) SetOption(e *mathjax) {
+	e.attributeLists = o.value
+}
+
+// splitAttributeList returns line without a trailing attribute list of
+// `#id` and `.class` items, along with its id and classes. If line does not
+// end with a valid list, it is returned unchanged with ok set to false.
+func splitAttributeList(line []byte) (rest []byte, id, class string, ok bool) {
+	trimmed := util.TrimRightSpace(line)
+	if !bytes.HasSuffix(trimmed, []byte("}")) {
+		return line, "", "", false
+	}
+	open := bytes.LastIndexByte(trimmed, '{')
+	if open < 0 {
+		return line, "", "", false
+	}
+	var classes []string
+	for _, item := range strings.Fields(string(trimmed[open+1 : len(trimmed)-1])) {
+		switch {
+		case len(item) > 1 && item[0] == '#':
+			id = item[1:]
+		case len(item) > 1 && item[0] == '.':
+			classes = append(classes, item[1:])
+		default:
+			return line, "", "", false
+		}
+	}
+	if id == "" && classes == nil {
+		return line, "", "", false
+	}
+	return trimmed[:open], id, strings.Join(classes, " "), true
+}
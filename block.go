@@ -1,6 +1,9 @@
 package mathjax
 
 import (
+	"bytes"
+	"sort"
+
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/text"
@@ -8,21 +11,69 @@ import (
 )
 
 type mathJaxBlockParser struct {
+	config *mathjax
 }
 
-var defaultMathJaxBlockParser = &mathJaxBlockParser{}
+var defaultMathJaxBlockParser = &mathJaxBlockParser{NewMathJax()}
 
 type mathBlockData struct {
-	indent int
+	node     ast.Node
+	indent   int
+	line     int
+	column   int
+	fenceLen int
+	closed   bool
+	// fenceIndent is the indentation width of the opening fence, even
+	// with WithPreserveBlockIndent.
+	fenceIndent int
 }
 
 var mathBlockInfoKey = parser.NewContextKey()
 
+var definitionMarker = []byte("!def")
+
+// lineIndex holds the offsets at which the lines of a source start.
+type lineIndex []int
+
+func newLineIndex(source []byte) lineIndex {
+	x := lineIndex{0}
+	for i := 0; ; {
+		j := bytes.IndexByte(source[i:], '\n')
+		if j < 0 {
+			return x
+		}
+		i += j + 1
+		x = append(x, i)
+	}
+}
+
+// line returns the 1-based line number of offset.
+func (x lineIndex) line(offset int) int {
+	return sort.Search(len(x), func(i int) bool { return x[i] > offset })
+}
+
+var lineIndexKey = parser.NewContextKey()
+
+// sourceLine returns the 1-based line number of offset in source, the
+// source of the document parsed with pc, whose line index is built on first
+// use.
+func sourceLine(pc parser.Context, source []byte, offset int) int {
+	x, ok := pc.Get(lineIndexKey).(lineIndex)
+	if !ok {
+		x = newLineIndex(source)
+		pc.Set(lineIndexKey, x)
+	}
+	return x.line(offset)
+}
+
 func NewMathJaxBlockParser() parser.BlockParser {
 	return defaultMathJaxBlockParser
 }
 
 func (b *mathJaxBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	if b.config.mathDisabled(pc) {
+		return nil, parser.NoChildren
+	}
 	line, segment := reader.PeekLine()
 	pos := pc.BlockOffset()
 	if pos == -1 {
@@ -36,38 +87,62 @@ func (b *mathJaxBlockParser) Open(parent ast.Node, reader text.Reader, pc parser
 	i := pos
 	for ; i < len(line) && line[i] == '$'; i++ {
 	}
-	if i-pos < 2 {
+	fenceLen := i - pos
+	if fenceLen < 2 {
 		return nil, parser.NoChildren
 	}
 
+	definition := false
+	if b.config.definitions && bytes.HasPrefix(line[i:], definitionMarker) {
+		j := i + len(definitionMarker)
+		if j >= len(line) || util.IsSpace(line[j]) {
+			definition = true
+			i = j
+			if i < len(line) && line[i] == ' ' {
+				i++
+			}
+		}
+	}
+
 	remainingLine := line[i:]
 
+	// `$$$$` is an empty same-line block rather than a longer opening fence
+	empty := fenceLen >= 4 && !definition && util.IsBlank(remainingLine)
+
 	// Check if closing $$ exists on the same line
-	// Look for at least 2 consecutive $ followed by blank/newline
-	closingPos := -1
-	for j := 0; j < len(remainingLine)-1; j++ {
-		if remainingLine[j] == '$' {
-			k := j
-			for k < len(remainingLine) && remainingLine[k] == '$' {
-				k++
-			}
-			closingLen := k - j
-			if closingLen >= 2 && util.IsBlank(remainingLine[k:]) {
-				// Found valid closing delimiter
-				closingPos = j
-				break
-			}
-			j = k - 1 // Skip the $ sequence we just checked
+	closingPos, id, class := b.closingFenceWithAttributes(remainingLine, fenceLen)
+
+	if !empty && closingPos <= 0 && (b.config.blockBlankLineAbandon || b.config.maxBlockLines > 0) {
+		fenceIndent, _ := util.IndentWidth(line, reader.LineOffset())
+		if !b.closedInTime(parent, reader.Source()[segment.Stop:], fenceIndent, fenceLen) {
+			// the fence stays text rather than opening inline math
+			abandonedFences(pc)[segment.Start-segment.Padding+pos] = true
+			return nil, parser.NoChildren
 		}
 	}
 
+	// the source offset of the start of line
+	lineStart := segment.Start - segment.Padding
+	node := b.config.openDisplayBlock(pc, reader.Source(), lineStart+pos, string(line[pos:pos+fenceLen]))
+	if node == nil {
+		return nil, parser.NoChildren
+	}
+
+	if empty {
+		node.stop = lineStart + pos + fenceLen
+		return node, parser.Close
+	}
+
 	if closingPos > 0 {
 		// Same-line format: $$content$$
-		node := NewMathBlock()
+		node.id, node.class = id, class
+		node.Definition = definition
+		node.stop = lineStart + i + closingPos + dollarRun(remainingLine[closingPos:])
 		content := remainingLine[:closingPos]
 		if len(content) > 0 {
 			// Add content to node (excluding opening and closing $$)
-			contentSegment := text.NewSegment(segment.Start+i, segment.Start+i+closingPos)
+			start := segment.Start - segment.Padding + i
+			contentSegment := text.NewSegment(start, start+closingPos)
 			node.Lines().Append(contentSegment)
 		}
 		// Don't advance reader - goldmark will do it automatically
@@ -76,12 +151,26 @@ func (b *mathJaxBlockParser) Open(parent ast.Node, reader text.Reader, pc parser
 	}
 
 	// Multi-line format: opening $$ on its own line or with content on first line
-	pc.Set(mathBlockInfoKey, &mathBlockData{indent: pos})
-	node := NewMathBlock()
+	node.Definition = definition
+	node.stop = lineStart + len(util.TrimRightSpace(line))
+	fenceIndent, _ := util.IndentWidth(line, reader.LineOffset())
+	indent := pos
+	if b.config.preserveBlockIndent {
+		// content lines are not dedented by the indentation of the fence
+		indent = 0
+	}
+	pc.Set(mathBlockInfoKey, &mathBlockData{
+		node:        node,
+		indent:      indent,
+		fenceIndent: fenceIndent,
+		line:        node.Line,
+		column:      sourceColumn(reader.Source(), node.start),
+		fenceLen:    fenceLen,
+	})
 
 	// If there's content after opening $$, save it as the first line
 	if len(remainingLine) > 0 && !util.IsBlank(remainingLine) {
-		contentSegment := text.NewSegment(segment.Start+i, segment.Stop)
+		contentSegment := text.NewSegment(segment.Start-segment.Padding+i, segment.Stop)
 		node.Lines().Append(contentSegment)
 	}
 
@@ -91,77 +180,317 @@ func (b *mathJaxBlockParser) Open(parent ast.Node, reader text.Reader, pc parser
 func (b *mathJaxBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
 	line, segment := reader.PeekLine()
 
-	// Safe get: check for nil before type assertion
-	dataInterface := pc.Get(mathBlockInfoKey)
-	if dataInterface == nil {
-		// Context has been cleared, this block should already be closed
+	// Same-line blocks have no data; goldmark keeps them open until the
+	// next line regardless of the Close returned by Open.
+	data, ok := pc.Get(mathBlockInfoKey).(*mathBlockData)
+	if !ok || data.node != node {
 		return parser.Close
 	}
-	data := dataInterface.(*mathBlockData)
 
-	// Check for closing $$ at the beginning of the line
-	w, pos := util.IndentWidth(line, 0)
-	if w < 4 {
-		i := pos
-		for ; i < len(line) && line[i] == '$'; i++ {
-		}
-		length := i - pos
-		if length >= 2 && util.IsBlank(line[i:]) {
-			reader.Advance(segment.Stop - segment.Start - segment.Padding)
-			return parser.Close
-		}
+	if b.config.blankLineInBlock == BlankLineTerminate && util.IsBlank(line) {
+		// the block stays unterminated and the blank line is parsed as
+		// usual
+		return parser.Close
 	}
 
-	// Check for closing $$ anywhere in the line (for same-line ending format)
-	// Search for $$ followed by blank/newline
-	closingPos := -1
-	for j := 0; j < len(line)-1; j++ {
-		if line[j] == '$' {
-			k := j
-			for k < len(line) && line[k] == '$' {
-				k++
-			}
-			closingLen := k - j
-			if closingLen >= 2 && util.IsBlank(line[k:]) {
-				// Found valid closing delimiter
-				closingPos = j
-				break
-			}
-			j = k - 1 // Skip the $ sequence we just checked
-		}
+	if i, id, class := b.closingFenceLine(line, reader.LineOffset(), data.fenceIndent, data.fenceLen); i >= 0 {
+		mb := node.(*MathBlock)
+		mb.id, mb.class = id, class
+		mb.stop = segment.Start - segment.Padding + i
+		data.closed = true
+		advanceToLineEnd(reader, line, segment)
+		return parser.Close
 	}
 
+	// Check for closing $$ anywhere in the line (for same-line ending format)
+	closingPos, id, class := b.closingFenceWithAttributes(line, data.fenceLen)
+
 	if closingPos >= 0 {
+		mb := node.(*MathBlock)
+		mb.id, mb.class = id, class
+		mb.stop = segment.Start - segment.Padding + closingPos + dollarRun(line[closingPos:])
 		// Found closing $$ on this line - add content before $$ and close
-		pos, padding := util.DedentPosition(line, 0, data.indent)
-		if closingPos > pos {
+		pos, padding := util.DedentPositionPadding(line, reader.LineOffset(), segment.Padding, data.indent)
+		if closingPos-segment.Padding > pos {
 			// Add content before the closing $$
-			contentEnd := segment.Start + closingPos
+			contentEnd := segment.Start - segment.Padding + closingPos
 			seg := text.NewSegmentPadding(segment.Start+pos, contentEnd, padding)
 			node.Lines().Append(seg)
 		}
-		reader.Advance(segment.Stop - segment.Start - segment.Padding)
+		data.closed = true
+		advanceToLineEnd(reader, line, segment)
 		return parser.Close
 	}
 
 	// No closing delimiter found - continue adding this line to the block
-	pos, padding := util.DedentPosition(line, 0, data.indent)
+	pos, padding := util.DedentPositionPadding(line, reader.LineOffset(), segment.Padding, data.indent)
 	seg := text.NewSegmentPadding(segment.Start+pos, segment.Stop, padding)
 	node.Lines().Append(seg)
+	node.(*MathBlock).stop = segment.Start - segment.Padding + len(util.TrimRightSpace(line))
 	reader.AdvanceAndSetPadding(segment.Stop-segment.Start-pos-1, padding)
 	return parser.Continue | parser.NoChildren
 }
 
+// closingFenceLine returns the end of the fence closing a block opened with
+// fenceLen dollars indented by fenceIndent columns when line, at column
+// offset, is such a fence: a run of dollars at the start of the line, which
+// may be indented by up to three columns more than the opening fence,
+// followed by blank characters or, with WithAttributeLists, an attribute
+// list. It also returns the id and classes of the list. It returns -1 for
+// other lines.
+func (b *mathJaxBlockParser) closingFenceLine(line []byte, offset, fenceIndent, fenceLen int) (int, string, string) {
+	w, pos := util.IndentWidth(line, offset)
+	if w >= fenceIndent+4 {
+		return -1, "", ""
+	}
+	i := pos + dollarRun(line[pos:])
+	length := i - pos
+	closes := length >= 2
+	if b.config.exactFenceLength {
+		closes = length == fenceLen
+	}
+	rest, id, class := line[i:], "", ""
+	if b.config.attributeLists {
+		if r, rid, rclass, ok := splitAttributeList(rest); ok && util.IsBlank(r) {
+			rest, id, class = r, rid, rclass
+		}
+	}
+	if !closes || !util.IsBlank(rest) {
+		return -1, "", ""
+	}
+	return i, id, class
+}
+
+// dollarRun returns the number of dollars at the start of b.
+func dollarRun(b []byte) int {
+	i := 0
+	for i < len(b) && b[i] == '$' {
+		i++
+	}
+	return i
+}
+
+// findClosingFence returns the index of the first run of at least fenceLen
+// dollars in line that is followed only by blank characters, or -1 if the
+// line has no such closing fence.
+func findClosingFence(line []byte, fenceLen int) int {
+	for j := 0; j < len(line); j++ {
+		if line[j] != '$' {
+			continue
+		}
+		k := j
+		for k < len(line) && line[k] == '$' {
+			k++
+		}
+		if k-j >= fenceLen && util.IsBlank(line[k:]) {
+			return j
+		}
+		j = k - 1 // Skip the $ sequence we just checked
+	}
+	return -1
+}
+
+// findExactClosingFence returns the index of the first run of exactly
+// fenceLen dollars in line that is followed only by blank characters, or -1.
+func findExactClosingFence(line []byte, fenceLen int) int {
+	for j := 0; j < len(line); j++ {
+		if line[j] != '$' {
+			continue
+		}
+		k := j
+		for k < len(line) && line[k] == '$' {
+			k++
+		}
+		if k-j == fenceLen && util.IsBlank(line[k:]) {
+			return j
+		}
+		j = k - 1
+	}
+	return -1
+}
+
+// closingFence returns the index of the fence closing a block opened with
+// openLen dollars in line, or -1.
+func (b *mathJaxBlockParser) closingFence(line []byte, openLen int) int {
+	var pos int
+	if b.config.exactFenceLength {
+		pos = findExactClosingFence(line, openLen)
+	} else {
+		pos = findClosingFence(line, 2)
+	}
+	// a fence is followed only by blank characters, so no other run of
+	// dollars can close the block when this one is escaped
+	if b.config.nestedFenceEscape && pos > 0 {
+		backslashes := 0
+		for i := pos - 1; i >= 0 && line[i] == '\\'; i-- {
+			backslashes++
+		}
+		if backslashes%2 == 1 {
+			return -1
+		}
+	}
+	return pos
+}
+
+// closingFenceWithAttributes is closingFence allowing an attribute list after
+// the fence with WithAttributeLists, and also returns its id and classes.
+func (b *mathJaxBlockParser) closingFenceWithAttributes(line []byte, openLen int) (int, string, string) {
+	if b.config.attributeLists {
+		if rest, id, class, ok := splitAttributeList(line); ok {
+			if pos := b.closingFence(rest, openLen); pos >= 0 {
+				return pos, id, class
+			}
+		}
+	}
+	return b.closingFence(line, openLen), "", ""
+}
+
+var abandonedFencesKey = parser.NewContextKey()
+
+// abandonedFences returns the source offsets of the fences in the document
+// of pc that did not open display math because their block would not be
+// closed in time.
+func abandonedFences(pc parser.Context) map[int]bool {
+	if v, ok := pc.Get(abandonedFencesKey).(map[int]bool); ok {
+		return v
+	}
+	m := map[int]bool{}
+	pc.Set(abandonedFencesKey, m)
+	return m
+}
+
+// closedInTime reports whether a line of rest, the source following the
+// opening line of a block opened in parent with openLen dollars indented by
+// fenceIndent columns, has its closing fence before the first blank line
+// with WithBlockBlankLineAbandon and within the limit of WithMaxBlockLines.
+// Lines are checked as Continue checks them, after the markers of the
+// blockquotes and the indentation of the list items around the block; a
+// line without them ends those containers, and with them the block.
+func (b *mathJaxBlockParser) closedInTime(parent ast.Node, rest []byte, fenceIndent, openLen int) bool {
+	var containers []ast.Node
+	for p := parent; p != nil; p = p.Parent() {
+		if p.Kind() == ast.KindBlockquote || p.Kind() == ast.KindListItem {
+			containers = append([]ast.Node{p}, containers...)
+		}
+	}
+	for n := 2; len(rest) > 0; n++ {
+		if max := b.config.maxBlockLines; max > 0 && n > max {
+			return false
+		}
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i+1], rest[i+1:]
+		} else {
+			rest = nil
+		}
+		content, ok := stripContainerPrefix(line, containers)
+		if !ok {
+			return false
+		}
+		if util.IsBlank(content) {
+			if b.config.blockBlankLineAbandon {
+				return false
+			}
+			continue
+		}
+		if i, _, _ := b.closingFenceLine(content, 0, fenceIndent, openLen); i >= 0 {
+			return true
+		}
+		if pos, _, _ := b.closingFenceWithAttributes(content, openLen); pos >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// stripContainerPrefix returns line without the markers of the blockquotes
+// and the indentation of the list items in containers, outermost first, and
+// reports whether they were all there. Blank lines continue list items.
+func stripContainerPrefix(line []byte, containers []ast.Node) ([]byte, bool) {
+	for _, c := range containers {
+		w, pos := util.IndentWidth(line, 0)
+		if item, ok := c.(*ast.ListItem); ok {
+			if util.IsBlank(line) {
+				return line, true
+			}
+			if w < item.Offset {
+				return nil, false
+			}
+			pos, _ = util.IndentPosition(line, 0, item.Offset)
+			line = line[pos:]
+			continue
+		}
+		if w > 3 || pos >= len(line) || line[pos] != '>' {
+			return nil, false
+		}
+		line = line[pos+1:]
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			line = line[1:]
+		}
+	}
+	return line, true
+}
+
+// advanceToLineEnd consumes the rest of the current line, including any
+// padding, but leaves the trailing newline so the reader stays on this line.
+func advanceToLineEnd(reader text.Reader, line []byte, segment text.Segment) {
+	newline := 0
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		newline = 1
+	}
+	reader.Advance(segment.Stop - segment.Start - newline + segment.Padding)
+}
+
 func (b *mathJaxBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+	// A multi-line block that is closed without seeing its closing fence
+	// ran into the end of the document (or of its container).
+	data, ok := pc.Get(mathBlockInfoKey).(*mathBlockData)
+	if !ok || data.node != node {
+		// a same-line block, which must leave the data of a block opened
+		// on the following line alone
+		return
+	}
+	if !data.closed {
+		b.config.reportUnterminatedBlock(pc, data.line, data.column)
+	}
 	pc.Set(mathBlockInfoKey, nil)
 }
 
+// openDisplayBlock counts a display block opened by the fence delim at
+// offset start of source against WithMaxMathNodes and returns it, or nil
+// over the limit. The caller sets the end of the block.
+func (e *mathjax) openDisplayBlock(pc parser.Context, source []byte, start int, delim string) *MathBlock {
+	if !e.takeMathNode(pc) {
+		return nil
+	}
+	node := NewMathBlock()
+	node.Line = sourceLine(pc, source, start)
+	node.delim = delim
+	node.start = start
+	return node
+}
+
+// reportUnterminatedBlock records a display block opened at line and column
+// that ran into the end of the document or its container, as a diagnostic
+// and, with WithStrict, a parse error.
+func (e *mathjax) reportUnterminatedBlock(pc parser.Context, line, column int) {
+	addDiagnostic(pc, line, "unterminated display math starting at line %d", line)
+	if e.strict {
+		addParseError(pc, &ParseError{
+			Line:    line,
+			Column:  column,
+			Kind:    "block",
+			Message: "unterminated display math",
+		})
+	}
+}
+
 func (b *mathJaxBlockParser) CanInterruptParagraph() bool {
 	return true
 }
 
 func (b *mathJaxBlockParser) CanAcceptIndentedLine() bool {
-	return false
+	return b.config.indentedBlocks
 }
 
 func (b *mathJaxBlockParser) Trigger() []byte {
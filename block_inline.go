@@ -7,6 +7,24 @@ import (
 
 type InlineMath struct {
 	ast.BaseInline
+
+	// Line is the 1-based source line of the opening delimiter.
+	Line int
+
+	// Index is the position of the node among all math nodes of the
+	// document, in document order.
+	Index int
+
+	// Display is set for display math written inside a paragraph, such
+	// as `\[x\]` with WithInlineLaTeXBrackets.
+	Display bool
+
+	// delim is the opening delimiter, e.g. `$` or `\[`.
+	delim string
+
+	// start and stop are the source offsets of the node, delimiters
+	// included.
+	start, stop int
 }
 
 func (n *InlineMath) Inline() {}
@@ -22,10 +40,20 @@ func (n *InlineMath) IsBlank(source []byte) bool {
 }
 
 func (n *InlineMath) Dump(source []byte, level int) {
-	ast.DumpHelper(n, source, level, nil, nil)
+	var m map[string]string
+	if n.Display {
+		m = map[string]string{"Display": "true"}
+	}
+	ast.DumpHelper(n, source, level, m, nil)
+}
+
+// SourceRange returns the offsets [start, end) of the node in the source,
+// including its delimiters.
+func (n *InlineMath) SourceRange() (start, end int) {
+	return n.start, n.stop
 }
 
-var KindInlineMath = ast.NewNodeKind("InlineMath")
+var KindInlineMath = ast.NewNodeKind("MathJaxInline")
 
 func (n *InlineMath) Kind() ast.NodeKind {
 	return KindInlineMath
@@ -1,12 +1,49 @@
 package mathjax
 
-import "github.com/yuin/goldmark/ast"
+import (
+	"strconv"
+
+	"github.com/yuin/goldmark/ast"
+)
 
 type MathBlock struct {
 	ast.BaseBlock
+
+	// Definition is set when the block opens with the `!def` marker.
+	Definition bool
+
+	// Line is the 1-based source line of the opening fence.
+	Line int
+
+	// Index is the position of the node among all math nodes of the
+	// document, in document order.
+	Index int
+
+	// Number is the equation number assigned by the equation index
+	// transformer, or 0 if the block is unnumbered.
+	Number int
+
+	// tex replaces the content of the lines when set, e.g. for blocks
+	// merged by the transformer.
+	tex []byte
+
+	// delim is the opening fence.
+	delim string
+
+	// id and class come from the attribute list after the closing fence,
+	// with WithAttributeLists.
+	id    string
+	class string
+
+	// environment is the name of the environment of a block opened with
+	// `\begin{name}` by WithAMSEnvironments.
+	environment string
+
+	// start and stop are the source offsets of the block, fences included.
+	start, stop int
 }
 
-var KindMathBlock = ast.NewNodeKind("MathBLock")
+var KindMathBlock = ast.NewNodeKind("MathJaxBlock")
 
 func NewMathBlock() *MathBlock {
 	return &MathBlock{}
@@ -14,9 +51,21 @@ func NewMathBlock() *MathBlock {
 
 func (n *MathBlock) Dump(source []byte, level int) {
 	m:= map[string]string{}
+	if n.Definition {
+		m["Definition"] = "true"
+	}
+	if n.Number > 0 {
+		m["Number"] = strconv.Itoa(n.Number)
+	}
 	ast.DumpHelper(n, source, level, m, nil)
 }
 
+// SourceRange returns the offsets [start, end) of the block in the source,
+// including its fences. An unterminated block ends with its last line.
+func (n *MathBlock) SourceRange() (start, end int) {
+	return n.start, n.stop
+}
+
 func (n *MathBlock) Kind() ast.NodeKind {
 	return KindMathBlock
 }
@@ -1,6 +1,8 @@
 package mathjax
 
 import (
+	"bytes"
+
 	gast "github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/util"
@@ -9,31 +11,281 @@ import (
 type MathBlockRenderer struct {
 	startDelim string
 	endDelim   string
+	config     *mathjax
 }
 
 func NewMathBlockRenderer(start, end string) renderer.NodeRenderer {
-	return &MathBlockRenderer{start, end}
+	return &MathBlockRenderer{start, end, NewMathJax()}
 }
 
 func (r *MathBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(KindMathBlock, r.renderMathBlock)
 }
 
-func (r *MathBlockRenderer) writeLines(w util.BufWriter, source []byte, n gast.Node) {
+// blockTeX returns the content of a display math block.
+func blockTeX(source []byte, n gast.Node) []byte {
+	if m, ok := n.(*MathBlock); ok && m.tex != nil {
+		return m.tex
+	}
+	var buf bytes.Buffer
 	l := n.Lines().Len()
 	for i := 0; i < l; i++ {
 		line := n.Lines().At(i)
-		w.Write(line.Value(source))
+		buf.Write(line.Value(source))
+	}
+	return buf.Bytes()
+}
+
+// blockMathTeX returns the TeX of a display math block as the renderers
+// write it, without zero-width runes, comments and disallowed commands as
+// configured.
+func (e *mathjax) blockMathTeX(source []byte, n gast.Node) []byte {
+	tex := e.stripZeroWidthRunes(blockTeX(source, n))
+	if e.stripTeXComments {
+		tex = stripTeXComments(tex)
+	}
+	return e.filterCommands(tex)
+}
+
+// blockSourceLen returns the length in bytes of the source of the TeX of a
+// display math block, before any of it is transformed.
+func blockSourceLen(source []byte, n *MathBlock) int {
+	l := n.Lines().Len()
+	if l == 0 {
+		return len(blockTeX(source, n))
+	}
+	length := 0
+	for i := 0; i < l; i++ {
+		line := n.Lines().At(i)
+		length += line.Stop - line.Start
+	}
+	return length
+}
+
+// canonicalTeX trims tex and collapses every run of white space in it to a
+// single space. `%` comments are stripped first, as they would otherwise
+// comment out the lines joined after them.
+func canonicalTeX(tex []byte) []byte {
+	return bytes.Join(bytes.Fields(stripTeXComments(tex)), []byte{' '})
+}
+
+// stripTeXComments removes `%` comments from tex, up to but not including
+// the end of their line, and drops lines that held nothing but a comment.
+// Escaped `\%` and `%` inside text-mode groups such as \text{...}, which
+// MathJax treats literally, are kept.
+func stripTeXComments(tex []byte) []byte {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(tex, []byte{'\n'}) {
+		depth, textLevel := 0, 0
+		comment := -1
+	scan:
+		for i := 0; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				if n := textGroupLength(line[i:]); n > 0 {
+					depth++
+					if textLevel == 0 {
+						textLevel = depth
+					}
+					i += n - 1
+				} else {
+					i++
+				}
+			case '{':
+				depth++
+			case '}':
+				if depth > 0 {
+					depth--
+				}
+				if depth < textLevel {
+					textLevel = 0
+				}
+			case '%':
+				if textLevel == 0 {
+					comment = i
+					break scan
+				}
+			}
+		}
+		if comment < 0 {
+			buf.Write(line)
+			continue
+		}
+		code := bytes.TrimRight(line[:comment], " \t")
+		if util.IsBlank(code) {
+			continue
+		}
+		buf.Write(code)
+		if bytes.HasSuffix(line, []byte{'\n'}) {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// inBlockquote reports whether n has a blockquote ancestor.
+func inBlockquote(n gast.Node) bool {
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Kind() == gast.KindBlockquote {
+			return true
+		}
+	}
+	return false
+}
+
+// appendTag returns tex with `\tag{number}` added on a line of its own after
+// the last line, so that a `%` comment on that line cannot swallow it.
+func appendTag(tex []byte, number string) []byte {
+	trimmed := bytes.TrimRight(tex, "\n")
+	out := make([]byte, 0, len(tex)+16)
+	out = append(out, trimmed...)
+	out = append(out, "\n\\tag{"+number+"}"...)
+	return append(out, tex[len(trimmed):]...)
+}
+
+func (r *MathBlockRenderer) writeOpeningTags(w util.BufWriter, source []byte, n *MathBlock, tex []byte) {
+	if r.config.accessibleBlocks {
+		_, _ = w.WriteString(`<div role="math" aria-label="`)
+		_, _ = w.Write(util.EscapeHTML(bytes.TrimSpace(tex)))
+		_, _ = w.WriteString(`"`)
+	} else {
+		_, _ = w.WriteString(`<p`)
 	}
+	if a := r.config.blockAlignment; a != "" {
+		_, _ = w.WriteString(` style="text-align:` + string(a) + `"`)
+	}
+	if r.config.accessibleBlocks {
+		_, _ = w.WriteString(` class="math display`)
+	} else {
+		_, _ = w.WriteString(`><` + r.config.wrapperElement() + ` class="math display`)
+	}
+	if n.Definition {
+		_, _ = w.WriteString(` math-def`)
+	}
+	if n.environment == "CD" {
+		// a hint to load the amscd component
+		_, _ = w.WriteString(` cd`)
+	}
+	if r.config.blockquoteClass && inBlockquote(n) {
+		_, _ = w.WriteString(` math-quoted`)
+	}
+	if n.class != "" {
+		_, _ = w.WriteString(` `)
+		_, _ = w.Write(util.EscapeHTML([]byte(n.class)))
+	}
+	_, _ = w.WriteString(`"`)
+	if n.id != "" {
+		_, _ = w.WriteString(` id="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(n.id)))
+		_, _ = w.WriteString(`"`)
+	} else if r.config.autoIDPrefix != "" {
+		writeAutoID(w, r.config.autoIDPrefix, n.Index)
+	}
+	if r.config.titleSource {
+		writeTitleSource(w, source, n.start, n.stop)
+	}
+	if r.config.noTranslate {
+		_, _ = w.WriteString(` translate="no"`)
+	}
+	if r.config.dir != "" {
+		_, _ = w.WriteString(` dir="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(r.config.dir)))
+		_, _ = w.WriteString(`"`)
+	}
+	if r.config.displayAttribute {
+		_, _ = w.WriteString(` data-display="true"`)
+	}
+	if r.config.debugAttributes {
+		writeDebugAttributes(w, "display", n.Line, n.delim, blockSourceLen(source, n))
+	}
+	_, _ = w.WriteString(`>`)
 }
 
 func (r *MathBlockRenderer) renderMathBlock(w util.BufWriter, source []byte, node gast.Node, entering bool) (gast.WalkStatus, error) {
+	if !entering {
+		return gast.WalkContinue, nil
+	}
 	n := node.(*MathBlock)
-	if entering {
-		_, _ = w.WriteString(`<p><span class="math display">` + r.startDelim)
-		r.writeLines(w, source, n)
+	tex := r.config.blockMathTeX(source, n)
+	if r.config.forceDisplayStyle && !util.IsBlank(tex) {
+		tex = append([]byte(`\displaystyle `), tex...)
+	}
+	if r.config.numberingMode == NumberingTag && n.Number > 0 {
+		tex = appendTag(tex, r.config.equationNumber(n.Number))
+	}
+	if bytes.HasSuffix(tex, []byte("\n")) {
+		switch r.config.finalNewline {
+		case FinalNewlineDrop:
+			tex = tex[:len(tex)-1]
+		case FinalNewlineSpace:
+			tex = append(tex[:len(tex)-1:len(tex)-1], ' ')
+		}
+	}
+	if r.config.onMath != nil {
+		written := tex
+		if r.config.writesCanonicalTeX() {
+			written = canonicalTeX(tex)
+		}
+		r.config.onMath(written, true, n.Line)
+	}
+	if util.IsBlank(tex) && r.config.emptyRender != "" {
+		writeEmptyMath(w, r.config.emptyRender)
+		if r.config.emptyRender == EmptyComment {
+			_, _ = w.WriteString("\n")
+		}
+		return gast.WalkSkipChildren, nil
+	}
+	_, _ = w.WriteString(r.config.wrapPrefix)
+	if r.config.template != nil {
+		if err := executeTemplate(w, r.config.template, tex, true, n.Line, n.Index); err != nil {
+			return gast.WalkStop, err
+		}
+		_, _ = w.WriteString(r.config.wrapSuffix)
+		return gast.WalkSkipChildren, nil
+	}
+	if r.config.mathJax3Containers {
+		_, _ = w.WriteString(`<mjx-container class="MathJax" jax="SVG" display="true">`)
+		writeScript(w, r.config.scriptNonce, "math/tex; mode=display", tex)
+		_, _ = w.WriteString(`</mjx-container>`)
+		_, _ = w.WriteString(r.config.wrapSuffix + "\n")
+		return gast.WalkSkipChildren, nil
+	}
+	if el := r.config.templateElement; el != "" {
+		writeTemplateElement(w, el, r.startDelim, tex, r.endDelim)
+		_, _ = w.WriteString(r.config.wrapSuffix + "\n")
+		return gast.WalkSkipChildren, nil
+	}
+	if r.config.canonicalOutput {
+		_, _ = w.WriteString(`<p><span class="math display">\[`)
+		_, _ = w.Write(canonicalTeX(tex))
+		_, _ = w.WriteString(`\]</span></p>`)
+		_, _ = w.WriteString(r.config.wrapSuffix + "\n")
+		return gast.WalkSkipChildren, nil
+	}
+	r.writeOpeningTags(w, source, n, tex)
+	writeMathML(w, r.config, tex, true)
+	_, _ = w.WriteString(r.startDelim)
+	r.config.writeWrappedTeX(w, tex)
+	_, _ = w.WriteString(r.endDelim)
+	writeImageNoScript(w, r.config, tex)
+	if r.config.sourceElement {
+		_, _ = w.WriteString(`<span class="math-source" hidden>`)
+		_, _ = w.Write(util.EscapeHTML(tex))
+		_, _ = w.WriteString(`</span>`)
+	}
+	if !r.config.accessibleBlocks {
+		_, _ = w.WriteString(`</` + r.config.wrapperElement() + `>`)
+	}
+	if r.config.numberingMode == NumberingDOM && n.Number > 0 {
+		_, _ = w.WriteString(`<span class="eqno">(`)
+		_, _ = w.Write(util.EscapeHTML([]byte(r.config.equationNumber(n.Number))))
+		_, _ = w.WriteString(`)</span>`)
+	}
+	if r.config.accessibleBlocks {
+		_, _ = w.WriteString(`</div>`)
 	} else {
-		_, _ = w.WriteString(r.endDelim + `</span></p>` + "\n")
+		_, _ = w.WriteString(`</p>`)
 	}
-	return gast.WalkContinue, nil
+	_, _ = w.WriteString(r.config.wrapSuffix + "\n")
+	return gast.WalkSkipChildren, nil
 }
@@ -0,0 +1,68 @@
+package mathjax
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindClosingFence(t *testing.T) {
+	tests := []struct {
+		line     string
+		fenceLen int
+		pos      int
+	}{
+		{"$$", 2, 0},
+		{"$$\n", 2, 0},
+		{"x+y$$", 2, 3},
+		{"x+y$$   \n", 2, 3},
+		{"x+y$$\t\n", 2, 3},
+		{"x+y$$$", 2, 3},
+		{"a$b$$", 2, 3},
+		{"a$$b$$", 2, 4},
+		{"a$$b", 2, -1},
+		{"a$", 2, -1},
+		{"a$ $", 2, -1},
+		{"no close", 2, -1},
+		{"", 2, -1},
+		{"x$$", 3, -1},
+		{"x$$$", 3, 1},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d: %q", i, tc.line), func(t *testing.T) {
+			assert.Equal(t, tc.pos, findClosingFence([]byte(tc.line), tc.fenceLen))
+		})
+	}
+}
+
+func TestFindExactClosingFence(t *testing.T) {
+	tests := []struct {
+		line     string
+		fenceLen int
+		pos      int
+	}{
+		{"$$", 2, 0},
+		{"x+y$$ \n", 2, 3},
+		{"x+y$$$", 2, -1},
+		{"x+y$$$", 3, 3},
+		{"a$$$b$$", 2, 5},
+		{"a$$", 3, -1},
+		{"", 2, -1},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d: %q", i, tc.line), func(t *testing.T) {
+			assert.Equal(t, tc.pos, findExactClosingFence([]byte(tc.line), tc.fenceLen))
+		})
+	}
+}
+
+func TestLineIndex(t *testing.T) {
+	source := []byte("a\nbc\n\nd")
+	x := newLineIndex(source)
+	for offset, line := range []int{1, 1, 2, 2, 2, 3, 4, 4} {
+		t.Run(fmt.Sprintf("%d", offset), func(t *testing.T) {
+			assert.Equal(t, line, x.line(offset))
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package mathjax
+
+import (
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// bracketMathParser parses `\[...\]` inside a paragraph into an InlineMath
+// node with Display set.
+type bracketMathParser struct {
+	config *mathjax
+}
+
+func (s *bracketMathParser) Trigger() []byte {
+	return []byte{'\\'}
+}
+
+func (s *bracketMathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if s.config.mathDisabled(pc) {
+		return nil
+	}
+	line, startSegment := block.PeekLine()
+	if len(line) < 2 || line[1] != '[' {
+		return nil
+	}
+	l, pos := block.Position()
+	block.Advance(2)
+	node := NewInlineMath()
+	node.Display = true
+	node.delim = `\[`
+	node.Line = sourceLine(pc, block.Source(), startSegment.Start)
+	for {
+		line, segment := block.PeekLine()
+		if line == nil {
+			block.SetPosition(l, pos)
+			return nil
+		}
+		for i := 0; i < len(line); i++ {
+			if line[i] != '\\' || i+1 >= len(line) {
+				continue
+			}
+			if line[i+1] == ']' {
+				if i > 0 {
+					node.AppendChild(node, ast.NewRawTextSegment(segment.WithStop(segment.Start+i)))
+				}
+				if !s.config.takeMathNode(pc) {
+					block.SetPosition(l, pos)
+					return nil
+				}
+				node.start, node.stop = startSegment.Start, segment.Start+i+2
+				block.Advance(i + 2)
+				return node
+			}
+			// skip the escaped character, e.g. `\\` before a `]`
+			i++
+		}
+		node.AppendChild(node, ast.NewRawTextSegment(segment))
+		block.AdvanceLine()
+	}
+}
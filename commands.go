@@ -0,0 +1,210 @@
+package mathjax
+
+import (
+	"bytes"
+	"strings"
+)
+
+type withCommandAllowlist struct {
+	names []string
+}
+
+// WithCommandAllowlist removes every TeX command not in names, along with its
+// arguments, from the TeX of math nodes. Names may be given with or without
+// the leading backslash. Control symbols such as `\\` and `\,` are kept.
+func WithCommandAllowlist(names []string) Option {
+	return &withCommandAllowlist{names}
+}
+
+func (o *withCommandAllowlist) SetOption(e *mathjax) {
+	e.commandAllowlist = commandSet(o.names)
+}
+
+type withCommandDenylist struct {
+	names []string
+}
+
+// WithCommandDenylist removes the TeX commands in names, along with their
+// arguments, from the TeX of math nodes, e.g. `\href` and `\input` for
+// untrusted input. A command built with `\csname name\endcsname` is removed
+// as the command name. Commands defined with `\def`, `\let` or
+// `\newcommand` are not tracked, so a denied command can be given a new
+// name with them: deny those too, or use WithCommandAllowlist, when the
+// input is untrusted.
+func WithCommandDenylist(names []string) Option {
+	return &withCommandDenylist{names}
+}
+
+func (o *withCommandDenylist) SetOption(e *mathjax) {
+	e.commandDenylist = commandSet(o.names)
+}
+
+func commandSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.TrimPrefix(name, `\`)] = true
+	}
+	return set
+}
+
+func (e *mathjax) commandAllowed(name string) bool {
+	if e.commandAllowlist != nil && !e.commandAllowlist[name] {
+		return false
+	}
+	return !e.commandDenylist[name]
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// filterCommands returns tex without the commands that are not allowed by
+// the allowlist and denylist. The arguments of a removed command, as many
+// bracketed and braced groups as commandArity gives it, are removed with it. The filter is repeated
+// until nothing is removed, since the text around a removed command can
+// join into a new command name, as in `\hr\href{}{}ef`.
+func (e *mathjax) filterCommands(tex []byte) []byte {
+	if e.commandAllowlist == nil && e.commandDenylist == nil {
+		return tex
+	}
+	for {
+		out := e.removeCommands(tex)
+		if len(out) == len(tex) {
+			return out
+		}
+		tex = out
+	}
+}
+
+// removeCommands makes one pass of filterCommands over tex.
+func (e *mathjax) removeCommands(tex []byte) []byte {
+	out := make([]byte, 0, len(tex))
+	for i := 0; i < len(tex); {
+		if tex[i] != '\\' || i+1 >= len(tex) || !isLetter(tex[i+1]) {
+			if tex[i] == '\\' && i+1 < len(tex) {
+				// a control symbol, kept with the character it escapes
+				out = append(out, tex[i], tex[i+1])
+				i += 2
+				continue
+			}
+			out = append(out, tex[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(tex) && isLetter(tex[j]) {
+			j++
+		}
+		name := string(tex[i+1 : j])
+		if name == "csname" {
+			// `\csname href\endcsname` is the command \href
+			if k := bytes.Index(tex[j:], endcsname); k >= 0 {
+				name = strings.TrimSpace(string(tex[j : j+k]))
+				if !e.commandAllowed("csname") || !e.commandAllowed(name) {
+					i = skipTeXArguments(tex, j+k+len(endcsname), commandArity(name))
+					continue
+				}
+			}
+			name = "csname"
+		}
+		if e.commandAllowed(name) {
+			out = append(out, tex[i:j]...)
+			i = j
+			continue
+		}
+		if j < len(tex) && tex[j] == '*' {
+			j++
+		}
+		i = skipTeXArguments(tex, j, commandArity(name))
+	}
+	return out
+}
+
+var endcsname = []byte(`\endcsname`)
+
+// texArity is the number of arguments of a TeX command: bracketed optional
+// ones, which come first, and braced required ones.
+type texArity struct {
+	optional, required int
+}
+
+// commandArities are the arguments of commands commonly filtered from
+// untrusted TeX.
+var commandArities = map[string]texArity{
+	"href":            {0, 2},
+	"url":             {0, 1},
+	"class":           {0, 2},
+	"cssId":           {0, 2},
+	"style":           {0, 2},
+	"data":            {0, 2},
+	"includegraphics": {1, 1},
+	"input":           {0, 1},
+	"include":         {0, 1},
+	"require":         {0, 1},
+	"unicode":         {1, 1},
+	"color":           {1, 1},
+	"textcolor":       {1, 2},
+	"colorbox":        {1, 2},
+	"fcolorbox":       {1, 3},
+}
+
+// commandArity returns the arguments of the command name: those in
+// commandArities, or one optional and one required argument.
+func commandArity(name string) texArity {
+	if a, ok := commandArities[name]; ok {
+		return a
+	}
+	return texArity{1, 1}
+}
+
+// skipTeXArguments returns the position after the arguments of a command
+// with arity a starting at i in tex: up to a.optional bracketed groups and
+// then up to a.required braced groups, which may be separated by blank
+// characters. Groups after those are kept, as are arguments that are not
+// groups.
+func skipTeXArguments(tex []byte, i int, a texArity) int {
+	for n := 0; n < a.optional; n++ {
+		end, ok := skipTeXGroup(tex, i, '[', ']')
+		if !ok {
+			break
+		}
+		i = end
+	}
+	for n := 0; n < a.required; n++ {
+		end, ok := skipTeXGroup(tex, i, '{', '}')
+		if !ok {
+			break
+		}
+		i = end
+	}
+	return i
+}
+
+// skipTeXGroup returns the position after the group delimited by open and
+// close that starts at i in tex after any blank characters, and whether
+// there is one. An unbalanced group extends to the end of the TeX.
+func skipTeXGroup(tex []byte, i int, open, close byte) (int, bool) {
+	j := i
+	for j < len(tex) && (tex[j] == ' ' || tex[j] == '\t' || tex[j] == '\n') {
+		j++
+	}
+	if j >= len(tex) || tex[j] != open {
+		return i, false
+	}
+	depth := 0
+	for k := j; k < len(tex); k++ {
+		if tex[k] == '\\' {
+			k++
+			continue
+		}
+		if tex[k] == open {
+			depth++
+		} else if tex[k] == close {
+			depth--
+			if depth == 0 {
+				return k + 1, true
+			}
+		}
+	}
+	return len(tex), true
+}
@@ -0,0 +1,125 @@
+package mathjax
+
+import (
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+type withContainerFence struct {
+	name string
+}
+
+// WithContainerFence makes a line `:::name` open display math that ends
+// with a line of only `:::`. Longer fences of colons may be used, and the
+// closing fence must be at least as long as the opening one, so that a
+// `::::name` block can contain `:::` lines.
+func WithContainerFence(name string) Option {
+	return &withContainerFence{name}
+}
+
+func (o *withContainerFence) SetOption(e *mathjax) {
+	e.containerFence = o.name
+}
+
+type containerFenceParser struct {
+	config *mathjax
+}
+
+type containerFenceData struct {
+	node     ast.Node
+	indent   int
+	line     int
+	fenceLen int
+	closed   bool
+}
+
+var containerFenceInfoKey = parser.NewContextKey()
+
+// colonRun returns the number of colons at the start of b.
+func colonRun(b []byte) int {
+	i := 0
+	for i < len(b) && b[i] == ':' {
+		i++
+	}
+	return i
+}
+
+func (b *containerFenceParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (b *containerFenceParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	if b.config.mathDisabled(pc) {
+		return nil, parser.NoChildren
+	}
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	fenceLen := colonRun(line[pos:])
+	if fenceLen < 3 {
+		return nil, parser.NoChildren
+	}
+	name := util.TrimRightSpace(util.TrimLeftSpace(line[pos+fenceLen:]))
+	if string(name) != b.config.containerFence {
+		return nil, parser.NoChildren
+	}
+	lineStart := segment.Start - segment.Padding
+	node := b.config.openDisplayBlock(pc, reader.Source(), lineStart+pos, string(line[pos:pos+fenceLen]))
+	if node == nil {
+		return nil, parser.NoChildren
+	}
+	node.stop = lineStart + len(util.TrimRightSpace(line))
+	pc.Set(containerFenceInfoKey, &containerFenceData{
+		node:     node,
+		indent:   pos,
+		line:     node.Line,
+		fenceLen: fenceLen,
+	})
+	advanceToLineEnd(reader, line, segment)
+	return node, parser.NoChildren
+}
+
+func (b *containerFenceParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	data, ok := pc.Get(containerFenceInfoKey).(*containerFenceData)
+	if !ok || data.node != node {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	mb := node.(*MathBlock)
+	if w, pos := util.IndentWidth(line, reader.LineOffset()); w < 4 {
+		if n := colonRun(line[pos:]); n >= data.fenceLen && util.IsBlank(line[pos+n:]) {
+			mb.stop = segment.Start - segment.Padding + pos + n
+			data.closed = true
+			advanceToLineEnd(reader, line, segment)
+			return parser.Close
+		}
+	}
+	pos, padding := util.DedentPositionPadding(line, reader.LineOffset(), segment.Padding, data.indent)
+	node.Lines().Append(text.NewSegmentPadding(segment.Start+pos, segment.Stop, padding))
+	mb.stop = segment.Start - segment.Padding + len(util.TrimRightSpace(line))
+	reader.AdvanceAndSetPadding(segment.Stop-segment.Start-pos-1, padding)
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *containerFenceParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+	data, ok := pc.Get(containerFenceInfoKey).(*containerFenceData)
+	if !ok || data.node != node {
+		return
+	}
+	if !data.closed {
+		b.config.reportUnterminatedBlock(pc, data.line, sourceColumn(reader.Source(), node.(*MathBlock).start))
+	}
+	pc.Set(containerFenceInfoKey, nil)
+}
+
+func (b *containerFenceParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *containerFenceParser) CanAcceptIndentedLine() bool {
+	return false
+}
@@ -0,0 +1,39 @@
+package mathjax
+
+import (
+	"fmt"
+
+	"github.com/yuin/goldmark/parser"
+)
+
+// Diagnostic describes a non-fatal problem found while parsing math.
+type Diagnostic struct {
+	Line    int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return d.Message
+}
+
+var diagnosticsKey = parser.NewContextKey()
+
+func addDiagnostic(pc parser.Context, line int, format string, args ...interface{}) {
+	var diags []Diagnostic
+	if v := pc.Get(diagnosticsKey); v != nil {
+		diags = v.([]Diagnostic)
+	}
+	pc.Set(diagnosticsKey, append(diags, Diagnostic{
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	}))
+}
+
+// Diagnostics returns the diagnostics recorded in pc while parsing.
+// Pass the same context to Convert with parser.WithContext to collect them.
+func Diagnostics(pc parser.Context) []Diagnostic {
+	if v := pc.Get(diagnosticsKey); v != nil {
+		return v.([]Diagnostic)
+	}
+	return nil
+}
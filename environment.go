@@ -0,0 +1,134 @@
+package mathjax
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+type withAMSEnvironments struct {
+	value bool
+}
+
+// WithAMSEnvironments makes a line that starts with `\begin{name}` for one of
+// the amsmath environments, or the amscd CD environment, open display math
+// without `$$`. The block ends with the line containing `\end{name}` and
+// keeps both commands in its TeX.
+func WithAMSEnvironments(value bool) Option {
+	return &withAMSEnvironments{value}
+}
+
+func (o *withAMSEnvironments) SetOption(e *mathjax) {
+	e.amsEnvironments = o.value
+}
+
+var amsEnvironments = map[string]bool{
+	"equation": true, "equation*": true,
+	"align": true, "align*": true,
+	"alignat": true, "alignat*": true,
+	"gather": true, "gather*": true,
+	"multline": true, "multline*": true,
+	"flalign": true, "flalign*": true,
+	"CD": true,
+}
+
+var beginCommand = []byte(`\begin{`)
+
+type environmentBlockParser struct {
+	config *mathjax
+}
+
+type environmentBlockData struct {
+	node   ast.Node
+	indent int
+	end    []byte
+}
+
+var environmentBlockInfoKey = parser.NewContextKey()
+
+// environmentName returns the name of the environment begun at the start of
+// line, or nil if line does not start with `\begin{name}`.
+func environmentName(line []byte) []byte {
+	if !bytes.HasPrefix(line, beginCommand) {
+		return nil
+	}
+	rest := line[len(beginCommand):]
+	end := bytes.IndexByte(rest, '}')
+	if end < 0 {
+		return nil
+	}
+	return rest[:end]
+}
+
+func (b *environmentBlockParser) Trigger() []byte {
+	return []byte{'\\'}
+}
+
+func (b *environmentBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	if b.config.mathDisabled(pc) {
+		return nil, parser.NoChildren
+	}
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	name := environmentName(line[pos:])
+	if name == nil || !amsEnvironments[string(name)] {
+		return nil, parser.NoChildren
+	}
+	node := b.config.openDisplayBlock(pc, reader.Source(), segment.Start-segment.Padding+pos, string(beginCommand)+string(name)+"}")
+	if node == nil {
+		return nil, parser.NoChildren
+	}
+	node.environment = string(name)
+	node.Lines().Append(text.NewSegment(segment.Start-segment.Padding+pos, segment.Stop))
+	end := []byte(`\end{` + string(name) + `}`)
+	if j := bytes.Index(line[pos+len(node.delim):], end); j >= 0 {
+		node.stop = node.start + len(node.delim) + j + len(end)
+		return node, parser.Close
+	}
+	node.stop = segment.Start - segment.Padding + len(util.TrimRightSpace(line))
+	pc.Set(environmentBlockInfoKey, &environmentBlockData{node, pos, end})
+	return node, parser.NoChildren
+}
+
+func (b *environmentBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	data, ok := pc.Get(environmentBlockInfoKey).(*environmentBlockData)
+	if !ok || data.node != node {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	if util.IsBlank(line) {
+		// like a paragraph, the environment ends at a blank line
+		return parser.Close
+	}
+	pos, padding := util.DedentPositionPadding(line, reader.LineOffset(), segment.Padding, data.indent)
+	node.Lines().Append(text.NewSegmentPadding(segment.Start+pos, segment.Stop, padding))
+	mb := node.(*MathBlock)
+	if j := bytes.Index(line, data.end); j >= 0 {
+		mb.stop = segment.Start - segment.Padding + j + len(data.end)
+		advanceToLineEnd(reader, line, segment)
+		return parser.Close
+	}
+	mb.stop = segment.Start - segment.Padding + len(util.TrimRightSpace(line))
+	reader.AdvanceAndSetPadding(segment.Stop-segment.Start-pos-1, padding)
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *environmentBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+	if data, ok := pc.Get(environmentBlockInfoKey).(*environmentBlockData); ok && data.node == node {
+		pc.Set(environmentBlockInfoKey, nil)
+	}
+}
+
+func (b *environmentBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *environmentBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
@@ -0,0 +1,52 @@
+package mathjax
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// RenderEquation renders a single equation with the default options,
+// without parsing Markdown. See (*mathjax).RenderEquation.
+func RenderEquation(tex string, display bool) (string, error) {
+	return MathJax.RenderEquation(tex, display)
+}
+
+// RenderEquation renders tex as display or inline math, producing the same
+// markup Convert would for a math node with that content, without parsing
+// Markdown. As with Convert, the TeX is written unescaped in the default
+// markup, so the result is not safe HTML for untrusted tex.
+func (e *mathjax) RenderEquation(tex string, display bool) (string, error) {
+	source := []byte(tex)
+	segment := text.NewSegment(0, len(source))
+
+	var buf bytes.Buffer
+	w := bufio.NewWriterSize(&buf, 2*len(source)+128)
+	var err error
+	if display {
+		n := NewMathBlock()
+		n.Line = 1
+		if len(source) > 0 {
+			n.Lines().Append(segment)
+		}
+		r := &MathBlockRenderer{e.blockStartDelim, e.blockEndDelim, e}
+		_, err = r.renderMathBlock(w, source, n, true)
+	} else {
+		n := NewInlineMath()
+		n.Line = 1
+		if len(source) > 0 {
+			n.AppendChild(n, ast.NewRawTextSegment(segment))
+		}
+		r := &InlineMathRenderer{e.inlineStartDelim, e.inlineEndDelim, e}
+		_, err = r.renderInlineMath(w, source, n, true)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
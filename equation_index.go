@@ -0,0 +1,105 @@
+package mathjax
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// IndexedEquation is an entry of the equation index built by the
+// transformer returned from NewEquationIndexTransformer.
+type IndexedEquation struct {
+	// Number is the 1-based number of the display equation.
+	Number int
+	// TeX is the content of the equation.
+	TeX string
+	// ID is the argument of the first \label in the equation, if any.
+	ID string
+}
+
+var equationIndexKey = parser.NewContextKey()
+
+type equationIndexTransformer struct {
+}
+
+// NewEquationIndexTransformer returns a transformer that numbers display
+// equations in document order and stores the list in the parser context,
+// where EquationIndex retrieves it. Equations that set or suppress their
+// own number with \tag, \notag or \nonumber are left unnumbered.
+func NewEquationIndexTransformer() parser.ASTTransformer {
+	return &equationIndexTransformer{}
+}
+
+func (t *equationIndexTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	index := []IndexedEquation{}
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if m, ok := n.(*MathBlock); ok && entering {
+			tex := blockTeX(source, m)
+			if numbersItself(tex) {
+				return ast.WalkContinue, nil
+			}
+			m.Number = len(index) + 1
+			index = append(index, IndexedEquation{
+				Number: m.Number,
+				TeX:    string(tex),
+				ID:     string(texLabel(tex)),
+			})
+		}
+		return ast.WalkContinue, nil
+	})
+	pc.Set(equationIndexKey, index)
+}
+
+// EquationIndex returns the equations collected in pc by the transformer
+// returned from NewEquationIndexTransformer.
+func EquationIndex(pc parser.Context) []IndexedEquation {
+	if v := pc.Get(equationIndexKey); v != nil {
+		return v.([]IndexedEquation)
+	}
+	return nil
+}
+
+// numberingCommands set or suppress the number of an equation.
+var numberingCommands = map[string]bool{"tag": true, "notag": true, "nonumber": true}
+
+// numbersItself reports whether tex has one of the numberingCommands.
+func numbersItself(tex []byte) bool {
+	for i := 0; i < len(tex); i++ {
+		if tex[i] != '\\' {
+			continue
+		}
+		j := i + 1
+		for j < len(tex) && isLetter(tex[j]) {
+			j++
+		}
+		if numberingCommands[string(tex[i+1:j])] {
+			return true
+		}
+		if j == i+1 {
+			// a control symbol such as `\\`
+			i++
+		} else {
+			i = j - 1
+		}
+	}
+	return false
+}
+
+var labelCommand = []byte(`\label{`)
+
+// texLabel returns the argument of the first \label command in tex.
+func texLabel(tex []byte) []byte {
+	i := bytes.Index(tex, labelCommand)
+	if i < 0 {
+		return nil
+	}
+	rest := tex[i+len(labelCommand):]
+	end := bytes.IndexByte(rest, '}')
+	if end < 0 {
+		return nil
+	}
+	return bytes.TrimSpace(rest[:end])
+}
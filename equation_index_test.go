@@ -0,0 +1,56 @@
+package mathjax
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/util"
+)
+
+func TestEquationIndex(t *testing.T) {
+	md := goldmark.New(
+		goldmark.WithExtensions(MathJax),
+		goldmark.WithParserOptions(parser.WithASTTransformers(
+			util.Prioritized(NewEquationIndexTransformer(), 100),
+		)),
+	)
+	src := []byte(`# Equations
+
+$$E = mc^2 \label{eq:energy}$$
+
+Inline $x$ is not indexed.
+
+$$
+a^2 + b^2 = c^2
+$$
+
+> $$\label{ eq:quoted } y$$
+`)
+	pc := parser.NewContext()
+	var buf bytes.Buffer
+	if err := md.Convert(src, &buf, parser.WithContext(pc)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []IndexedEquation{
+		{Number: 1, TeX: `E = mc^2 \label{eq:energy}`, ID: "eq:energy"},
+		{Number: 2, TeX: "a^2 + b^2 = c^2\n", ID: ""},
+		{Number: 3, TeX: `\label{ eq:quoted } y`, ID: "eq:quoted"},
+	}, EquationIndex(pc))
+
+	pc = parser.NewContext()
+	buf.Reset()
+	if err := md.Convert([]byte("no math"), &buf, parser.WithContext(pc)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, EquationIndex(pc))
+
+	pc = parser.NewContext()
+	buf.Reset()
+	if err := md.Convert([]byte("$$a \\tag{x}$$\n\n$$b \\\\ c \\nonumber$$\n\n$$d \\\\tag$$"), &buf, parser.WithContext(pc)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []IndexedEquation{{Number: 1, TeX: `d \\tag`}}, EquationIndex(pc))
+}
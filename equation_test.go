@@ -0,0 +1,67 @@
+package mathjax
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/goldmark"
+)
+
+func TestRenderEquation(t *testing.T) {
+	tests := []struct {
+		d       string
+		e       *mathjax
+		tex     string
+		display bool
+		out     string
+	}{
+		{"inline", MathJax, `x^2`, false, `<span class="math inline">\(x^2\)</span>`},
+		{"display", MathJax, `x^2`, true, `<p><span class="math display">\[x^2\]</span></p>` + "\n"},
+		{"empty", MathJax, ``, false, `<span class="math inline">\(\)</span>`},
+		{"delimiters", NewMathJax(WithInlineDelim("$", "$")), `x`, false, `<span class="math inline">$x$</span>`},
+		{"wrap", NewMathJax(WithWrap("<m>", "</m>")), `x`, false, `<m><span class="math inline">\(x\)</span></m>`},
+		{
+			"source element escapes",
+			NewMathJax(WithSourceElement(true)),
+			`a<b`,
+			true,
+			`<p><span class="math display">\[a<b\]<span class="math-source" hidden>a&lt;b</span></span></p>` + "\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.d, func(t *testing.T) {
+			out, err := tc.e.RenderEquation(tc.tex, tc.display)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.out, out)
+		})
+	}
+
+	out, err := RenderEquation(`x`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `<span class="math inline">\(x\)</span>`, out)
+}
+
+func BenchmarkRenderEquation(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := RenderEquation(`\frac{a}{b} + \sqrt{c}`, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConvertEquation(b *testing.B) {
+	md := goldmark.New(goldmark.WithExtensions(MathJax))
+	src := []byte(`$$\frac{a}{b} + \sqrt{c}$$`)
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := md.Convert(src, &buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
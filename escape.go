@@ -0,0 +1,72 @@
+package mathjax
+
+import (
+	"strings"
+)
+
+// EscapeTeX returns s escaped for use as the body of inline or display math,
+// as in "$" + EscapeTeX(s) + "$", so that user-supplied TeX renders as a
+// single math node and can't close it early. It only prevents delimiter
+// injection and is not an HTML sanitizer: the renderers write TeX unescaped
+// unless WithCodeWrapper is set. Dollars are escaped as `\$`, the
+// delimiters `\(`, `\)`, `\[` and `\]` are written as `\backslash(` and so on,
+// unbalanced braces are escaped, and line breaks are replaced with spaces. A
+// trailing backslash, which would escape the closing dollar, is dropped.
+func EscapeTeX(s string) string {
+	s = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s)
+	s = strings.TrimSpace(s)
+	unbalanced := unbalancedBraces(s)
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			b.WriteString(`\$`)
+		case c == '\\' && i+1 == len(s):
+		case c == '\\':
+			switch s[i+1] {
+			case '(', ')', '[', ']':
+				b.WriteString(`\backslash`)
+			default:
+				b.WriteByte(c)
+			}
+			i++
+			b.WriteByte(s[i])
+		case unbalanced[i]:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if b.Len() == 0 || strings.HasSuffix(b.String(), "$") {
+		// a `\$` right before the closer would join a `$$` fence
+		b.WriteString("{}")
+	}
+	return b.String()
+}
+
+// unbalancedBraces returns the offsets of the braces of s that have no
+// matching brace, skipping escaped characters.
+func unbalancedBraces(s string) map[int]bool {
+	var open []int
+	unbalanced := map[int]bool{}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			open = append(open, i)
+		case '}':
+			if len(open) == 0 {
+				unbalanced[i] = true
+			} else {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	for _, i := range open {
+		unbalanced[i] = true
+	}
+	return unbalanced
+}
@@ -0,0 +1,58 @@
+package mathjax
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeTeX(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{in: `\frac{1}{2}`, out: `\frac{1}{2}`},
+		{in: `a$b`, out: `a\$b`},
+		{in: `x$$ <b>y</b> $$z`, out: `x\$\$ <b>y</b> \$\$z`},
+		{in: `\$ is already escaped`, out: `\$ is already escaped`},
+		{in: `a\] \[b\) \(c`, out: `a\backslash] \backslash[b\backslash) \backslash(c`},
+		{in: `\\] \\)`, out: `\\] \\)`},
+		{in: `\text{a`, out: `\text\{a`},
+		{in: `a} {b}`, out: `a\} {b}`},
+		{in: "a\n\nb\r\nc", out: "a  b c"},
+		{in: ` x `, out: `x`},
+		{in: `x\`, out: `x`},
+		{in: `x$`, out: `x\${}`},
+		{in: ``, out: `{}`},
+		{in: `   `, out: `{}`},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d: %q", i, tc.in), func(t *testing.T) {
+			out := EscapeTeX(tc.in)
+			assert.Equal(t, tc.out, out)
+			assert.NoError(t, ValidateInline("$"+out+"$"))
+			assert.NoError(t, ValidateBlock("$$"+out+"$$"))
+		})
+	}
+}
+
+func TestEscapeTeXRendering(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "$" + EscapeTeX(`x$ **y** $z`) + "$ after",
+			out: `<p><span class="math inline">\(x\$ **y** \$z\)</span> after</p>`,
+		},
+		{
+			d:   "double dollars in a paragraph",
+			in:  "a $$" + EscapeTeX(`x$$ y \)`) + "$$ b",
+			out: `<p>a <span class="math inline">\(x\$\$ y \backslash)\)</span> b</p>`,
+		},
+		{
+			d:   "bracket delimiters",
+			in:  `\[` + EscapeTeX(`x \] y`) + `\]`,
+			out: `<p><span class="math display">\[x \backslash] y\]</span></p>`,
+		},
+	}, NewMathJax(WithInlineLaTeXBrackets(true)))
+}
@@ -0,0 +1,48 @@
+package mathjax
+
+import (
+	"github.com/yuin/goldmark/ast"
+)
+
+// Math is a math node found by ExtractMath.
+type Math struct {
+	// TeX is the content of the node without its delimiters.
+	TeX string
+
+	// Display is set for display math blocks.
+	Display bool
+
+	// Line is the 1-based source line the node starts on.
+	Line int
+}
+
+// ExtractMath returns the math nodes of the Markdown document source in
+// document order. It returns nil if source has no math. See
+// (*mathjax).ExtractMath.
+func ExtractMath(source []byte) []Math {
+	return MathJax.ExtractMath(source)
+}
+
+// ExtractMath returns the math nodes of the Markdown document source, parsed
+// with the options of the extension, in document order. TeX is filtered by
+// WithCommandAllowlist and WithCommandDenylist. It returns nil if source has
+// no math.
+func (e *mathjax) ExtractMath(source []byte) []Math {
+	doc, src, _ := e.parseForValidation(string(source))
+	var maths []Math
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch m := n.(type) {
+		case *MathBlock:
+			maths = append(maths, Math{string(e.filterCommands(blockTeX(src, m))), true, m.Line})
+			return ast.WalkSkipChildren, nil
+		case *InlineMath:
+			maths = append(maths, Math{string(e.filterCommands(inlineTeX(src, m))), false, m.Line})
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return maths
+}
@@ -0,0 +1,42 @@
+package mathjax
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractMath(t *testing.T) {
+	src := "# $a$\n\nText $b + c$ and\n\n$$\nd\n$$\n\n> $$e$$\n"
+	assert.Equal(t, []Math{
+		{TeX: "a", Display: false, Line: 1},
+		{TeX: "b + c", Display: false, Line: 3},
+		{TeX: "d\n", Display: true, Line: 5},
+		{TeX: "e", Display: true, Line: 9},
+	}, ExtractMath([]byte(src)))
+}
+
+func TestHelpersDegenerateInputs(t *testing.T) {
+	tests := []struct {
+		in       string
+		inline   error
+		block    error
+		extracts int
+	}{
+		{in: "", inline: ErrEmptyMath, block: ErrEmptyMath},
+		{in: " ", inline: ErrEmptyMath, block: ErrEmptyMath},
+		{in: "\n\n", inline: ErrEmptyMath, block: ErrEmptyMath},
+		{in: " \t\n \n", inline: ErrEmptyMath, block: ErrEmptyMath},
+		{in: "$", inline: ErrUnclosedMath, block: ErrNotSingleMath},
+		{in: " $\n", inline: ErrUnclosedMath, block: ErrNotSingleMath},
+		{in: "$$", inline: ErrNotSingleMath, block: ErrUnclosedMath, extracts: 1},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d: %q", i, tc.in), func(t *testing.T) {
+			assert.Equal(t, tc.inline, ValidateInline(tc.in))
+			assert.Equal(t, tc.block, ValidateBlock(tc.in))
+			assert.Len(t, ExtractMath([]byte(tc.in)), tc.extracts)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+//go:build go1.18
+// +build go1.18
+
+package mathjax
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzConvert(f *testing.F) {
+	for _, tc := range mathJaxTests {
+		f.Add([]byte(tc.in))
+	}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		out, err := renderMarkdown(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if utf8.Valid(src) && !utf8.Valid(out) {
+			t.Fatalf("invalid UTF-8 output for %q: %q", src, out)
+		}
+	})
+}
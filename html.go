@@ -0,0 +1,194 @@
+package mathjax
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// ErrUnterminatedTag is returned by RenderMathInHTML when a tag or comment
+// is not closed.
+var ErrUnterminatedTag = errors.New("mathjax: unterminated HTML tag")
+
+// htmlSkipElements are the elements whose text is never scanned for math.
+var htmlSkipElements = map[string]bool{
+	"code":     true,
+	"pre":      true,
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"math":     true,
+}
+
+// RenderMathInHTML replaces the math in the text of already rendered HTML
+// with the default options. See (*mathjax).RenderMathInHTML.
+func RenderMathInHTML(html []byte) ([]byte, error) {
+	return MathJax.RenderMathInHTML(html)
+}
+
+// RenderMathInHTML replaces `$...$` and `$$...$$` in the text of html with
+// math spans, leaving the markup and the content of code, pre, script,
+// style, textarea and math elements alone. Display math becomes a
+// `math display` span rather than a paragraph, since it may be in the
+// middle of other text. The text is already HTML-encoded: its TeX is
+// decoded for the renderer, which encodes it again wherever it writes it,
+// including WithTemplate templates and WithMathJax3Containers scripts.
+func (e *mathjax) RenderMathInHTML(html []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	config := *e
+	config.escapeRawTeX = true
+	r := &InlineMathRenderer{e.inlineStartDelim, e.inlineEndDelim, &config}
+	state := &htmlMathState{lines: newLineIndex(html)}
+	skip := 0
+	for i := 0; i < len(html); {
+		if html[i] == '<' {
+			end, name, closing, err := scanTag(html, i)
+			if err != nil {
+				return nil, err
+			}
+			if htmlSkipElements[name] {
+				if closing {
+					if skip > 0 {
+						skip--
+					}
+				} else if !bytes.HasSuffix(html[i:end], []byte("/>")) {
+					skip++
+				}
+			}
+			_, _ = w.Write(html[i:end])
+			i = end
+			continue
+		}
+		j := i
+		for j < len(html) && html[j] != '<' {
+			j++
+		}
+		if skip > 0 {
+			_, _ = w.Write(html[i:j])
+		} else if err := renderMathInText(w, r, html, state, i, j); err != nil {
+			return nil, err
+		}
+		i = j
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scanTag returns the end of the tag, comment or declaration starting at
+// html[start], which is a `<`, and the lower-case name of the element it
+// opens or closes. A `>` in a quoted attribute value doesn't end the tag.
+func scanTag(html []byte, start int) (end int, name string, closing bool, err error) {
+	if bytes.HasPrefix(html[start:], []byte("<!--")) {
+		k := bytes.Index(html[start+4:], []byte("-->"))
+		if k < 0 {
+			return 0, "", false, ErrUnterminatedTag
+		}
+		return start + 4 + k + 3, "", false, nil
+	}
+	for k := start + 1; end == 0; k++ {
+		if k >= len(html) {
+			return 0, "", false, ErrUnterminatedTag
+		}
+		switch html[k] {
+		case '"', '\'':
+			q := bytes.IndexByte(html[k+1:], html[k])
+			if q < 0 {
+				return 0, "", false, ErrUnterminatedTag
+			}
+			k += q + 1
+		case '>':
+			end = k + 1
+		}
+	}
+	i := start + 1
+	if i < end && html[i] == '/' {
+		closing = true
+		i++
+	}
+	j := i
+	for j < end && ('a' <= html[j] && html[j] <= 'z' || 'A' <= html[j] && html[j] <= 'Z' || '0' <= html[j] && html[j] <= '9') {
+		j++
+	}
+	return end, string(bytes.ToLower(html[i:j])), closing, nil
+}
+
+// htmlMathState is the state of RenderMathInHTML across runs of text.
+type htmlMathState struct {
+	lines lineIndex
+	count int
+}
+
+// renderMathInText writes html[start:stop], a run of text, with its math
+// rendered by r.
+func renderMathInText(w *bufio.Writer, r *InlineMathRenderer, html []byte, state *htmlMathState, start, stop int) error {
+	last := start
+	for i := start; i < stop; i++ {
+		switch html[i] {
+		case '\\':
+			i++
+		case '$':
+			n := 1
+			if i+1 < stop && html[i+1] == '$' {
+				n = 2
+			}
+			closer := findHTMLMathCloser(html[:stop], i+n, n)
+			if closer < 0 {
+				i += n - 1
+				continue
+			}
+			_, _ = w.Write(html[last:i])
+			// the node is rendered from a copy of the math with its TeX
+			// decoded
+			tex := util.ResolveEntityNames(util.ResolveNumericReferences(html[i+n : closer]))
+			source := make([]byte, 0, len(tex)+2*n)
+			source = append(append(append(source, html[i:i+n]...), tex...), html[i:i+n]...)
+			node := NewInlineMath()
+			node.Display = n == 2
+			node.delim = string(html[i : i+n])
+			node.Line = state.lines.line(i)
+			node.Index = state.count
+			state.count++
+			node.start, node.stop = 0, len(source)
+			node.AppendChild(node, ast.NewRawTextSegment(text.NewSegment(n, n+len(tex))))
+			if _, err := r.renderInlineMath(w, source, node, true); err != nil {
+				return err
+			}
+			i = closer + n - 1
+			last = closer + n
+		}
+	}
+	_, _ = w.Write(html[last:stop])
+	return nil
+}
+
+// findHTMLMathCloser returns the index of the first run of exactly n
+// unescaped dollars at or after start in b, or -1 if there is none or the
+// math before it is blank.
+func findHTMLMathCloser(b []byte, start, n int) int {
+	for i := start; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '$':
+			j := i
+			for j < len(b) && b[j] == '$' {
+				j++
+			}
+			if j-i == n {
+				if len(bytes.TrimSpace(b[start:i])) == 0 {
+					return -1
+				}
+				return i
+			}
+			i = j - 1
+		}
+	}
+	return -1
+}
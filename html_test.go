@@ -0,0 +1,126 @@
+package mathjax
+
+import (
+	"fmt"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMathInHTML(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{
+			in:  `<p>Let $x$ be</p>`,
+			out: `<p>Let <span class="math inline">\(x\)</span> be</p>`,
+		},
+		{
+			in:  `<p>so $$a = b$$ holds</p>`,
+			out: `<p>so <span class="math display">\[a = b\]</span> holds</p>`,
+		},
+		{
+			in:  `<p>$a &lt; b$ and <em>$c$</em></p>`,
+			out: `<p><span class="math inline">\(a &lt; b\)</span> and <em><span class="math inline">\(c\)</span></em></p>`,
+		},
+		{
+			in:  `<p>Run <code>echo $HOME $PATH</code> then $y$</p>`,
+			out: `<p>Run <code>echo $HOME $PATH</code> then <span class="math inline">\(y\)</span></p>`,
+		},
+		{
+			in:  "<pre><code>$x$\n</code></pre>\n<p>$y$</p>",
+			out: "<pre><code>$x$\n</code></pre>\n<p><span class=\"math inline\">\\(y\\)</span></p>",
+		},
+		{
+			in:  `<script>var s = "$x$";</script><style>/* $y$ */</style>`,
+			out: `<script>var s = "$x$";</script><style>/* $y$ */</style>`,
+		},
+		{
+			in:  `<!-- $x$ --><p>costs \$5 or $ $</p>`,
+			out: `<!-- $x$ --><p>costs \$5 or $ $</p>`,
+		},
+		{
+			in:  `<p>$x</p><p>y$</p>`,
+			out: `<p>$x</p><p>y$</p>`,
+		},
+		{
+			in:  `<img alt="a>$x$" src='b>$y$'> $z$`,
+			out: `<img alt="a>$x$" src='b>$y$'> <span class="math inline">\(z\)</span>`,
+		},
+		{
+			in:  `<p>$a &#60; b &amp; c$</p>`,
+			out: `<p><span class="math inline">\(a &lt; b &amp; c\)</span></p>`,
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			out, err := RenderMathInHTML([]byte(tc.in))
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.out, string(out))
+			}
+		})
+	}
+}
+
+func TestRenderMathInHTMLUnterminatedTag(t *testing.T) {
+	for _, in := range []string{`<p $x$`, `<!-- $x$`, `<a title="$x$>`} {
+		_, err := RenderMathInHTML([]byte(in))
+		assert.Equal(t, ErrUnterminatedTag, err, in)
+	}
+}
+
+func TestRenderMathInHTMLOptions(t *testing.T) {
+	out, err := NewMathJax(WithDollarOutput(true)).RenderMathInHTML([]byte(`<p>$x$</p>`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, `<p><span class="math inline">$x$</span></p>`, string(out))
+	}
+}
+
+func TestRenderMathInHTMLEscaping(t *testing.T) {
+	in := []byte(`<p>$a &lt; b$</p>`)
+	for _, tc := range []struct {
+		d   string
+		e   *mathjax
+		out string
+	}{
+		{"code wrapper", NewMathJax(WithCodeWrapper(true)), `<p><code class="math inline">\(a &lt; b\)</code></p>`},
+		{"noscript", NewMathJax(WithNoScriptFallback(true)), `<p><span class="math inline"><noscript>a &lt; b</noscript>\(a &lt; b\)</span></p>`},
+		{"canonical output", NewMathJax(WithCanonicalOutput(true)), `<p><span class="math inline">\(a &lt; b\)</span></p>`},
+		{"script", NewMathJax(WithMathJax3Containers(true)), `<p><mjx-container class="MathJax" jax="SVG"><script type="math/tex">a &lt; b</script></mjx-container></p>`},
+		{"template", NewMathJax(WithTemplate(template.Must(template.New("math").Parse(`<m>{{.TeX}}</m>`)))), `<p><m>a &lt; b</m></p>`},
+		{"template element", NewMathJax(WithTemplateElement("math-tex")), `<p><math-tex><template>\(a &lt; b\)</template></math-tex></p>`},
+	} {
+		t.Run(tc.d, func(t *testing.T) {
+			out, err := tc.e.RenderMathInHTML(in)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.out, string(out))
+			}
+		})
+	}
+}
+
+func TestRenderMathInHTMLDecodedMarkup(t *testing.T) {
+	in := []byte(`<p>$&lt;img src=x onerror=alert(1)&gt;$</p>`)
+	tmpl := template.Must(template.New("math").Parse(`{{.TeX}}`))
+	for _, e := range []*mathjax{
+		MathJax,
+		NewMathJax(WithTemplate(tmpl)),
+		NewMathJax(WithMathJax3Containers(true)),
+		NewMathJax(WithTemplateElement("math-tex")),
+		NewMathJax(WithCanonicalOutput(true)),
+	} {
+		out, err := e.RenderMathInHTML(in)
+		if assert.NoError(t, err) {
+			assert.NotContains(t, string(out), "<img")
+		}
+	}
+}
+
+func TestRenderMathInHTMLAutoIDs(t *testing.T) {
+	out, err := NewMathJax(WithAutoIDs("eq-")).RenderMathInHTML([]byte("<p>$a$ $b$</p>\n<p>$c$</p>"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "<p><span class=\"math inline\" id=\"eq-0\">\\(a\\)</span> <span class=\"math inline\" id=\"eq-1\">\\(b\\)</span></p>\n<p><span class=\"math inline\" id=\"eq-2\">\\(c\\)</span></p>", string(out))
+	}
+}
@@ -0,0 +1,39 @@
+package mathjax
+
+import (
+	"github.com/yuin/goldmark/util"
+)
+
+type withImageNoScriptFallback struct {
+	src func(tex []byte) (string, error)
+}
+
+// WithImageNoScriptFallback adds an image of display math blocks in a
+// <noscript> element after the TeX, for clients without JavaScript. src
+// returns the URL of the image of tex, e.g. a data URI or the address of a
+// rendering service; the escaped TeX is its alt text. Math for which src
+// returns an error gets no image.
+func WithImageNoScriptFallback(src func(tex []byte) (string, error)) Option {
+	return &withImageNoScriptFallback{src}
+}
+
+func (o *withImageNoScriptFallback) SetOption(e *mathjax) {
+	e.imageSrc = o.src
+}
+
+// writeImageNoScript writes the <noscript> image of tex if an image source
+// is configured and succeeds.
+func writeImageNoScript(w util.BufWriter, e *mathjax, tex []byte) {
+	if e.imageSrc == nil {
+		return
+	}
+	src, err := e.imageSrc(tex)
+	if err != nil {
+		return
+	}
+	_, _ = w.WriteString(`<noscript><img src="`)
+	_, _ = w.Write(util.EscapeHTML([]byte(src)))
+	_, _ = w.WriteString(`" alt="`)
+	_, _ = w.Write(util.EscapeHTML(tex))
+	_, _ = w.WriteString(`"></noscript>`)
+}
@@ -1,6 +1,9 @@
 package mathjax
 
 import (
+	"bytes"
+	"unicode/utf8"
+
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
@@ -9,9 +12,10 @@ import (
 )
 
 type inlineMathParser struct {
+	config *mathjax
 }
 
-var defaultInlineMathParser = &inlineMathParser{}
+var defaultInlineMathParser = &inlineMathParser{NewMathJax()}
 
 func NewInlineMathParser() parser.InlineParser {
 	return defaultInlineMathParser
@@ -22,27 +26,105 @@ func (s *inlineMathParser) Trigger() []byte {
 }
 
 func (s *inlineMathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if s.config.mathDisabled(pc) {
+		return nil
+	}
 	line, startSegment := block.PeekLine()
 	opener := 0
 	for ; opener < len(line) && line[opener] == '$'; opener++ {
 	}
+	if followsEscapedDollar(block.Source(), startSegment.Start) {
+		// `\$$` is a literal `$$`: the escape covers the whole run
+		block.Advance(opener)
+		return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
+	}
+	if s.config.noOpenAfterDigit && startSegment.Start > 0 && util.IsNumeric(block.Source()[startSegment.Start-1]) {
+		// the whole run stays text, so that `2$$x$$` doesn't open at the
+		// second dollar
+		block.Advance(opener)
+		return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
+	}
+	if opener > 1 && abandonedFences(pc)[startSegment.Start] {
+		block.Advance(opener)
+		return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
+	}
+	if s.config.shellVarGuard && opener == 1 && isShellVariable(line[opener:]) {
+		return nil
+	}
+	if s.config.flankingRules && (opener == len(line) || util.IsSpace(line[opener])) {
+		return nil
+	}
 	block.Advance(opener)
 	l, pos := block.Position()
 	node := NewInlineMath()
+	node.Line = sourceLine(pc, block.Source(), startSegment.Start)
+	node.delim = string(line[:opener])
+	// Dollars inside a text-mode group such as \text{...} belong to nested
+	// math and never close the node; textLevel is the brace depth of the
+	// outermost such group, or 0 outside of one.
+	depth, textLevel := 0, 0
+	// inText is set once a text-mode group was seen, after which a failed
+	// scan says nothing about the openers that follow
+	inText := false
+	stop := startSegment.Start
+	unclosed := unclosedInlineSpans(pc)
 	for {
 		line, segment := block.PeekLine()
+		if line != nil && unclosed[opener].contains(segment.Start) {
+			// an earlier scan found no closer from before here to the
+			// end of the paragraph
+			line = nil
+		}
 		if line == nil {
+			if !inText && stop > startSegment.Start {
+				unclosed[opener] = unclosedSpan{startSegment.Start, stop}
+			}
+			if s.config.strict {
+				addParseError(pc, &ParseError{
+					Line:    node.Line,
+					Column:  sourceColumn(block.Source(), startSegment.Start),
+					Kind:    "inline",
+					Message: "unterminated inline math",
+				})
+			}
 			block.SetPosition(l, pos)
 			return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
 		}
 		for i := 0; i < len(line); i++ {
 			c := line[i]
-			if c == '$' {
+			switch c {
+			case '\\':
+				if n := textGroupLength(line[i:]); n > 0 {
+					depth++
+					if textLevel == 0 {
+						textLevel = depth
+					}
+					inText = true
+					i += n - 1
+				} else {
+					// skip the escaped character, e.g. \$ or \}
+					i++
+				}
+			case '{':
+				depth++
+			case '}':
+				if depth > 0 {
+					depth--
+				}
+				if depth < textLevel {
+					textLevel = 0
+				}
+			case '$':
 				oldi := i
 				for ; i < len(line) && line[i] == '$'; i++ {
 				}
 				closure := i - oldi
-				if closure == opener && (i+1 >= len(line) || line[i+1] != '$') {
+				// under the flanking rules a closer directly follows the
+				// content, not white space or a line break
+				flanked := !s.config.flankingRules || oldi > 0 && !util.IsSpace(line[oldi-1])
+				beforeDigit := i < len(line) && '0' <= line[i] && line[i] <= '9'
+				if textLevel == 0 && closure == opener && flanked && !(beforeDigit && s.config.noCloseBeforeDigit) {
+					node.start, node.stop = startSegment.Start, segment.Start+i
 					segment := segment.WithStop(segment.Start + i - closure)
 					if !segment.IsEmpty() {
 						node.AppendChild(node, ast.NewRawTextSegment(segment))
@@ -50,15 +132,22 @@ func (s *inlineMathParser) Parse(parent ast.Node, block text.Reader, pc parser.C
 					block.Advance(i)
 					goto end
 				}
+				i--
 			}
 		}
 		if !util.IsBlank(line) {
 			node.AppendChild(node, ast.NewRawTextSegment(segment))
 		}
+		stop = segment.Stop
 		block.AdvanceLine()
 	}
 end:
 
+	if max := s.config.maxInlineLength; max > 0 && runeCount(node, block.Source()) > max || !s.config.takeMathNode(pc) {
+		block.SetPosition(l, pos)
+		return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
+	}
+
 	if !node.IsBlank(block.Source()) {
 		// trim first halfspace and last halfspace
 		segment := node.FirstChild().(*ast.Text).Segment
@@ -83,6 +172,92 @@ end:
 	return node
 }
 
+var unclosedInlineKey = parser.NewContextKey()
+
+// unclosedSpan is a range of source offsets in which a scan found no
+// closer for an opener.
+type unclosedSpan struct {
+	start, stop int
+}
+
+func (s unclosedSpan) contains(offset int) bool {
+	return s.start < offset && offset < s.stop
+}
+
+// unclosedInlineSpans returns the spans of failed scans of the document by
+// opener length, so that openers following an unclosed one in the same
+// paragraph are not scanned again to its end.
+func unclosedInlineSpans(pc parser.Context) map[int]unclosedSpan {
+	if v, ok := pc.Get(unclosedInlineKey).(map[int]unclosedSpan); ok {
+		return v
+	}
+	m := map[int]unclosedSpan{}
+	pc.Set(unclosedInlineKey, m)
+	return m
+}
+
+// followsEscapedDollar reports whether the dollar at offset in source
+// directly follows a backslash-escaped dollar.
+func followsEscapedDollar(source []byte, offset int) bool {
+	if offset < 2 || source[offset-1] != '$' {
+		return false
+	}
+	backslashes := 0
+	for i := offset - 2; i >= 0 && source[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+var textGroupCommands = [][]byte{[]byte(`\text`), []byte(`\mbox`)}
+
+// textGroupLength returns the length of a text-mode command and its opening
+// brace (e.g. `\text{`, `\textbf{`, `\mbox{`) at the start of b, or 0.
+func textGroupLength(b []byte) int {
+	for _, cmd := range textGroupCommands {
+		if !bytes.HasPrefix(b, cmd) {
+			continue
+		}
+		i := len(cmd)
+		for i < len(b) && 'a' <= b[i] && b[i] <= 'z' {
+			i++
+		}
+		if i < len(b) && b[i] == '{' {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// isShellVariable reports whether b, the text following a `$`, looks like
+// the rest of a shell variable such as `HOME/bin` rather than math: an
+// identifier of at least two uppercase letters, digits or underscores
+// followed by a `/`, a `:` or the end of the line. Whitespace does not end
+// a variable, as in the math `$AB = CD$`, whose closing dollar would
+// otherwise be left to open math with the next one.
+func isShellVariable(b []byte) bool {
+	if len(b) == 0 || !(b[0] == '_' || 'A' <= b[0] && b[0] <= 'Z') {
+		return false
+	}
+	i := 1
+	for i < len(b) && (b[i] == '_' || 'A' <= b[i] && b[i] <= 'Z' || '0' <= b[i] && b[i] <= '9') {
+		i++
+	}
+	if i < 2 {
+		return false
+	}
+	return i == len(b) || b[i] == '/' || b[i] == ':' || b[i] == '\n' || b[i] == '\r'
+}
+
+// runeCount returns the number of characters in the content of n.
+func runeCount(n ast.Node, source []byte) int {
+	count := 0
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		count += utf8.RuneCount(c.(*ast.Text).Segment.Value(source))
+	}
+	return count
+}
+
 func NewInlineMathRenderer(start, end string) renderer.NodeRenderer {
-	return &InlineMathRenderer{start, end}
+	return &InlineMathRenderer{start, end, NewMathJax()}
 }
@@ -2,6 +2,7 @@ package mathjax
 
 import (
 	"bytes"
+	"strconv"
 
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/renderer"
@@ -11,27 +12,163 @@ import (
 type InlineMathRenderer struct {
 	startDelim string
 	endDelim string
+	config   *mathjax
 }
 
-func (r *InlineMathRenderer) renderInlineMath(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
-	if entering {
-		_, _ = w.WriteString(`<span class="math inline">` + r.startDelim)
-		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
-			segment := c.(*ast.Text).Segment
-			value := segment.Value(source)
-			if bytes.HasSuffix(value, []byte("\n")) {
-				w.Write(value[:len(value)-1])
-				if c != n.LastChild() {
-					w.Write([]byte(" "))
-				}
-			} else {
-				w.Write(value)
+// inlineTeX returns the content of an inline math node, joining the lines
+// it spans with a single space.
+func inlineTeX(source []byte, n ast.Node) []byte {
+	var buf bytes.Buffer
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		segment := c.(*ast.Text).Segment
+		value := segment.Value(source)
+		if bytes.HasSuffix(value, []byte("\n")) {
+			// trailing spaces, such as those of a hard line break, are
+			// dropped along with the newline
+			buf.Write(bytes.TrimRight(value[:len(value)-1], " \t"))
+			if c != n.LastChild() {
+				buf.Write([]byte(" "))
 			}
+		} else {
+			buf.Write(value)
 		}
+	}
+	return buf.Bytes()
+}
+
+// inlineSourceLen returns the length in bytes of the source of the TeX of an
+// inline math node, before any of it is transformed.
+func inlineSourceLen(n ast.Node) int {
+	length := 0
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		segment := c.(*ast.Text).Segment
+		length += segment.Stop - segment.Start
+	}
+	return length
+}
+
+// inlineMathTeX returns the TeX of an inline math node as the renderers
+// write it, without zero-width runes and disallowed commands as configured.
+func (e *mathjax) inlineMathTeX(source []byte, n ast.Node) []byte {
+	return e.filterCommands(e.stripZeroWidthRunes(inlineTeX(source, n)))
+}
+
+func (r *InlineMathRenderer) writeOpeningTag(w util.BufWriter, source []byte, n *InlineMath, tex []byte) {
+	if n.Display {
+		_, _ = w.WriteString(`<` + r.config.wrapperElement() + ` class="math display`)
+	} else {
+		_, _ = w.WriteString(`<` + r.config.wrapperElement() + ` class="math inline`)
+	}
+	if r.config.blockquoteClass && inBlockquote(n) {
+		_, _ = w.WriteString(` math-quoted`)
+	}
+	_, _ = w.WriteString(`"`)
+	if r.config.autoIDPrefix != "" {
+		writeAutoID(w, r.config.autoIDPrefix, n.Index)
+	}
+	if r.config.titleSource {
+		writeTitleSource(w, source, n.start, n.stop)
+	}
+	if r.config.noTranslate {
+		_, _ = w.WriteString(` translate="no"`)
+	}
+	if r.config.dir != "" {
+		_, _ = w.WriteString(` dir="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(r.config.dir)))
+		_, _ = w.WriteString(`"`)
+	}
+	if r.config.displayAttribute {
+		_, _ = w.WriteString(` data-display="` + strconv.FormatBool(n.Display) + `"`)
+	}
+	if r.config.debugAttributes {
+		kind := "inline"
+		if n.Display {
+			kind = "display"
+		}
+		writeDebugAttributes(w, kind, n.Line, n.delim, inlineSourceLen(n))
+	}
+	_, _ = w.WriteString(`>`)
+}
+
+func (r *InlineMathRenderer) renderInlineMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*InlineMath)
+	tex := r.config.inlineMathTeX(source, n)
+	if r.config.inlineDisplayStyle && n.delim == "$$" && !util.IsBlank(tex) {
+		tex = append([]byte(`\displaystyle `), tex...)
+	}
+	if r.config.onMath != nil {
+		written := tex
+		if r.config.writesCanonicalTeX() {
+			written = canonicalTeX(tex)
+		}
+		r.config.onMath(written, n.Display, n.Line)
+	}
+	if util.IsBlank(tex) && r.config.emptyRender != "" {
+		writeEmptyMath(w, r.config.emptyRender)
+		return ast.WalkSkipChildren, nil
+	}
+	_, _ = w.WriteString(r.config.wrapPrefix)
+	if r.config.template != nil {
+		if err := executeTemplate(w, r.config.template, r.config.rawTeX(tex), n.Display, n.Line, n.Index); err != nil {
+			return ast.WalkStop, err
+		}
+		_, _ = w.WriteString(r.config.wrapSuffix)
+		return ast.WalkSkipChildren, nil
+	}
+	if r.config.mathJax3Containers {
+		if n.Display {
+			_, _ = w.WriteString(`<mjx-container class="MathJax" jax="SVG" display="true">`)
+			writeScript(w, r.config.scriptNonce, "math/tex; mode=display", r.config.rawTeX(tex))
+		} else {
+			_, _ = w.WriteString(`<mjx-container class="MathJax" jax="SVG">`)
+			writeScript(w, r.config.scriptNonce, "math/tex", r.config.rawTeX(tex))
+		}
+		_, _ = w.WriteString(`</mjx-container>`)
+		_, _ = w.WriteString(r.config.wrapSuffix)
 		return ast.WalkSkipChildren, nil
 	}
-	_, _ = w.WriteString(r.endDelim + `</span>`)
-	return ast.WalkContinue, nil
+	if el := r.config.templateElement; el != "" {
+		if n.Display {
+			writeTemplateElement(w, el, r.config.blockStartDelim, tex, r.config.blockEndDelim)
+		} else {
+			writeTemplateElement(w, el, r.startDelim, tex, r.endDelim)
+		}
+		_, _ = w.WriteString(r.config.wrapSuffix)
+		return ast.WalkSkipChildren, nil
+	}
+	if r.config.canonicalOutput {
+		canonical := r.config.rawTeX(canonicalTeX(tex))
+		if n.Display {
+			_, _ = w.WriteString(`<span class="math display">\[`)
+			_, _ = w.Write(canonical)
+			_, _ = w.WriteString(`\]</span>`)
+		} else {
+			_, _ = w.WriteString(`<span class="math inline">\(`)
+			_, _ = w.Write(canonical)
+			_, _ = w.WriteString(`\)</span>`)
+		}
+		_, _ = w.WriteString(r.config.wrapSuffix)
+		return ast.WalkSkipChildren, nil
+	}
+	r.writeOpeningTag(w, source, n, tex)
+	writeMathML(w, r.config, tex, n.Display)
+	if r.config.noScriptFallback {
+		_, _ = w.WriteString(`<noscript>`)
+		_, _ = w.Write(util.EscapeHTML(tex))
+		_, _ = w.WriteString(`</noscript>`)
+	}
+	start, end := r.startDelim, r.endDelim
+	if n.Display {
+		start, end = r.config.blockStartDelim, r.config.blockEndDelim
+	}
+	_, _ = w.WriteString(start)
+	r.config.writeWrappedTeX(w, tex)
+	_, _ = w.WriteString(end + `</` + r.config.wrapperElement() + `>`)
+	_, _ = w.WriteString(r.config.wrapSuffix)
+	return ast.WalkSkipChildren, nil
 }
 
 func (r *InlineMathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
@@ -0,0 +1,27 @@
+package mathjax
+
+import "github.com/yuin/goldmark/ast"
+
+// TeXLiteralBlock is a fenced block whose TeX is shown verbatim instead of
+// being rendered as math. See WithLiteralFence.
+type TeXLiteralBlock struct {
+	ast.BaseBlock
+}
+
+var KindTeXLiteralBlock = ast.NewNodeKind("MathJaxTeXLiteralBlock")
+
+func NewTeXLiteralBlock() *TeXLiteralBlock {
+	return &TeXLiteralBlock{}
+}
+
+func (n *TeXLiteralBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+func (n *TeXLiteralBlock) Kind() ast.NodeKind {
+	return KindTeXLiteralBlock
+}
+
+func (n *TeXLiteralBlock) IsRaw() bool {
+	return true
+}
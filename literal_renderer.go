@@ -0,0 +1,32 @@
+package mathjax
+
+import (
+	gast "github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+type TeXLiteralRenderer struct {
+}
+
+func NewTeXLiteralRenderer() renderer.NodeRenderer {
+	return &TeXLiteralRenderer{}
+}
+
+func (r *TeXLiteralRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindTeXLiteralBlock, r.renderTeXLiteralBlock)
+}
+
+func (r *TeXLiteralRenderer) renderTeXLiteralBlock(w util.BufWriter, source []byte, node gast.Node, entering bool) (gast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString(`<pre class="tex-literal"><code>`)
+		l := node.Lines().Len()
+		for i := 0; i < l; i++ {
+			line := node.Lines().At(i)
+			_, _ = w.Write(util.EscapeHTML(line.Value(source)))
+		}
+	} else {
+		_, _ = w.WriteString("</code></pre>\n")
+	}
+	return gast.WalkContinue, nil
+}
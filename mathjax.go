@@ -1,17 +1,87 @@
 package mathjax
 
 import (
+	"strconv"
+	"strings"
+	"text/template"
+
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
 	"github.com/yuin/goldmark/util"
 )
 
 type mathjax struct {
-	inlineStartDelim string
-	inlineEndDelim   string
-	blockStartDelim  string
-	blockEndDelim    string
+	inlineStartDelim      string
+	inlineEndDelim        string
+	blockStartDelim       string
+	blockEndDelim         string
+	indentedBlocks        bool
+	definitions           bool
+	template              *template.Template
+	literalFence          string
+	maxInlineLength       int
+	noScriptFallback      bool
+	blockAlignment        Alignment
+	sourceElement         bool
+	wrapPrefix            string
+	wrapSuffix            string
+	shellVarGuard         bool
+	blockquoteClass       bool
+	canonicalOutput       bool
+	mathJax3Containers    bool
+	metaToggleKey         string
+	metaGetter            func(pc parser.Context) map[string]interface{}
+	mergeAdjacentDisplay  bool
+	stripTeXComments      bool
+	displayAttribute      bool
+	mathMLConverter       MathMLConverter
+	mathMLDisplayAttr     bool
+	onMath                func(tex []byte, display bool, line int)
+	flankingRules         bool
+	inlineLaTeXBrackets   bool
+	preserveBlockIndent   bool
+	noTranslate           bool
+	strict                bool
+	emptyRender           EmptyRenderMode
+	debugAttributes       bool
+	codeWrapper           bool
+	mathInRawHTML         bool
+	exactFenceLength      bool
+	forceDisplayStyle     bool
+	maxMathNodes          int
+	noCloseBeforeDigit    bool
+	nestedFenceEscape     bool
+	mergeSeparator        string
+	scriptNonce           func() string
+	templateElement       string
+	attributeLists        bool
+	numberingMode         NumberingMode
+	commandAllowlist      map[string]bool
+	commandDenylist       map[string]bool
+	amsEnvironments       bool
+	finalNewline          FinalNewlineMode
+	mathFence             string
+	blankLineInBlock      BlankLineMode
+	titleSource           bool
+	refChecking           bool
+	containerFence        string
+	blockBlankLineAbandon bool
+	maxBlockLines         int
+	autoIDPrefix          string
+	dir                   string
+	accessibleBlocks      bool
+	texAnnotation         bool
+	inlineDisplayStyle    bool
+	equationNumberFunc    func(seq int) string
+	stripZeroWidth        bool
+	zeroWidthRunes        []rune
+	imageSrc              func(tex []byte) (string, error)
+	noOpenAfterDigit      bool
+	// escapeRawTeX is set for RenderMathInHTML, whose TeX is decoded from
+	// HTML text, so that it is escaped where it is otherwise written raw.
+	escapeRawTeX bool
 }
 
 type Option interface {
@@ -46,6 +116,882 @@ func (o *withBlockDelim) SetOption(e *mathjax) {
 	e.blockEndDelim = o.end
 }
 
+type withDollarOutput struct {
+	value bool
+}
+
+// WithDollarOutput writes `$...$` around inline math and `$$...$$` around
+// display math instead of the default `\(...\)` and `\[...\]`. It sets the
+// same delimiters as WithInlineDelim and WithBlockDelim; whichever option
+// comes last wins.
+func WithDollarOutput(value bool) Option {
+	return &withDollarOutput{value}
+}
+
+func (o *withDollarOutput) SetOption(e *mathjax) {
+	if o.value {
+		e.inlineStartDelim, e.inlineEndDelim = "$", "$"
+		e.blockStartDelim, e.blockEndDelim = "$$", "$$"
+	} else {
+		e.inlineStartDelim, e.inlineEndDelim = `\(`, `\)`
+		e.blockStartDelim, e.blockEndDelim = `\[`, `\]`
+	}
+}
+
+type withIndentedBlocks struct {
+	value bool
+}
+
+// WithIndentedBlocks lets a `$$` fence indented by four or more spaces open
+// display math instead of an indented code block.
+func WithIndentedBlocks(value bool) Option {
+	return &withIndentedBlocks{value}
+}
+
+func (o *withIndentedBlocks) SetOption(e *mathjax) {
+	e.indentedBlocks = o.value
+}
+
+type withDefinitions struct {
+	value bool
+}
+
+// WithDefinitions recognizes display math opened with `$$!def` as a
+// definition and renders it with the additional `math-def` class.
+func WithDefinitions(value bool) Option {
+	return &withDefinitions{value}
+}
+
+func (o *withDefinitions) SetOption(e *mathjax) {
+	e.definitions = o.value
+}
+
+type withLiteralFence struct {
+	language string
+}
+
+// WithLiteralFence renders fenced code blocks whose info string is language
+// as escaped literal TeX in a `tex-literal` block, so examples such as `$x$`
+// can be shown without being typeset.
+func WithLiteralFence(language string) Option {
+	return &withLiteralFence{language}
+}
+
+func (o *withLiteralFence) SetOption(e *mathjax) {
+	e.literalFence = o.language
+}
+
+type withMaxInlineLength struct {
+	max int
+}
+
+// WithMaxInlineLength leaves inline math whose content is longer than max
+// characters (runes, not bytes) as literal text. Zero means no limit.
+func WithMaxInlineLength(max int) Option {
+	return &withMaxInlineLength{max}
+}
+
+func (o *withMaxInlineLength) SetOption(e *mathjax) {
+	e.maxInlineLength = o.max
+}
+
+type withNoScriptFallback struct {
+	value bool
+}
+
+// WithNoScriptFallback adds the escaped TeX of inline math in a <noscript>
+// element, so readers without JavaScript still see the source.
+func WithNoScriptFallback(value bool) Option {
+	return &withNoScriptFallback{value}
+}
+
+func (o *withNoScriptFallback) SetOption(e *mathjax) {
+	e.noScriptFallback = o.value
+}
+
+// Alignment is a WithBlockAlignment alignment of display math.
+type Alignment string
+
+const (
+	// AlignLeft aligns display math to the left.
+	AlignLeft Alignment = "left"
+	// AlignCenter centers display math, as MathJax does by default.
+	AlignCenter Alignment = "center"
+	// AlignRight aligns display math to the right.
+	AlignRight Alignment = "right"
+)
+
+type withBlockAlignment struct {
+	alignment Alignment
+}
+
+// WithBlockAlignment aligns display math AlignLeft, AlignCenter or
+// AlignRight. Center is the MathJax default and adds no markup. Alignments
+// are case-insensitive, and any other alignment is AlignCenter.
+func WithBlockAlignment(alignment Alignment) Option {
+	return &withBlockAlignment{alignment}
+}
+
+func (o *withBlockAlignment) SetOption(e *mathjax) {
+	switch a := Alignment(strings.ToLower(string(o.alignment))); a {
+	case AlignLeft, AlignRight:
+		e.blockAlignment = a
+	default:
+		e.blockAlignment = ""
+	}
+}
+
+type withSourceElement struct {
+	value bool
+}
+
+// WithSourceElement appends the escaped TeX of display math in a hidden
+// `math-source` span, e.g. for a "copy LaTeX" button.
+func WithSourceElement(value bool) Option {
+	return &withSourceElement{value}
+}
+
+func (o *withSourceElement) SetOption(e *mathjax) {
+	e.sourceElement = o.value
+}
+
+type withWrap struct {
+	prefix string
+	suffix string
+}
+
+// WithWrap writes prefix before and suffix after the markup of every math
+// node, inline and display.
+func WithWrap(prefix, suffix string) Option {
+	return &withWrap{prefix, suffix}
+}
+
+func (o *withWrap) SetOption(e *mathjax) {
+	e.wrapPrefix = o.prefix
+	e.wrapSuffix = o.suffix
+}
+
+type withShellVarGuard struct {
+	value bool
+}
+
+// WithShellVarGuard keeps shell variables such as `$HOME/bin` from opening
+// inline math. See isShellVariable for the heuristic.
+func WithShellVarGuard(value bool) Option {
+	return &withShellVarGuard{value}
+}
+
+func (o *withShellVarGuard) SetOption(e *mathjax) {
+	e.shellVarGuard = o.value
+}
+
+type withBlockquoteClass struct {
+	value bool
+}
+
+// WithBlockquoteClass adds the `math-quoted` class to math inside a
+// blockquote.
+func WithBlockquoteClass(value bool) Option {
+	return &withBlockquoteClass{value}
+}
+
+func (o *withBlockquoteClass) SetOption(e *mathjax) {
+	e.blockquoteClass = o.value
+}
+
+type withCanonicalOutput struct {
+	value bool
+}
+
+// WithCanonicalOutput renders math in a stable minimal form for golden-file
+// tests: the TeX is trimmed with white space collapsed to single spaces,
+// the default \( \) and \[ \] delimiters are used and no extra classes,
+// attributes or elements are added.
+func WithCanonicalOutput(value bool) Option {
+	return &withCanonicalOutput{value}
+}
+
+func (o *withCanonicalOutput) SetOption(e *mathjax) {
+	e.canonicalOutput = o.value
+}
+
+type withMathJax3Containers struct {
+	value bool
+}
+
+// WithMathJax3Containers emits MathJax v3 <mjx-container> placeholders
+// holding the TeX in a math/tex script instead of delimited spans.
+func WithMathJax3Containers(value bool) Option {
+	return &withMathJax3Containers{value}
+}
+
+func (o *withMathJax3Containers) SetOption(e *mathjax) {
+	e.mathJax3Containers = o.value
+}
+
+type withMetaToggleKey struct {
+	key string
+	get func(pc parser.Context) map[string]interface{}
+}
+
+// WithMetaToggleKey disables math for documents whose metadata sets key to
+// false, e.g. `math: false` in front matter. get returns the metadata of the
+// document being parsed; with github.com/yuin/goldmark-meta pass meta.Get.
+func WithMetaToggleKey(key string, get func(pc parser.Context) map[string]interface{}) Option {
+	return &withMetaToggleKey{key, get}
+}
+
+func (o *withMetaToggleKey) SetOption(e *mathjax) {
+	e.metaToggleKey = o.key
+	e.metaGetter = o.get
+}
+
+// mathDisabled reports whether the metadata of the document being parsed
+// turns math off.
+func (e *mathjax) mathDisabled(pc parser.Context) bool {
+	if e.metaToggleKey == "" || e.metaGetter == nil {
+		return false
+	}
+	switch v := e.metaGetter(pc)[e.metaToggleKey]; v {
+	case false, "false":
+		return true
+	}
+	return false
+}
+
+type withMergeAdjacentDisplay struct {
+	value bool
+}
+
+// WithMergeAdjacentDisplay merges display blocks that follow each other
+// without a blank line into a single aligned block.
+func WithMergeAdjacentDisplay(value bool) Option {
+	return &withMergeAdjacentDisplay{value}
+}
+
+func (o *withMergeAdjacentDisplay) SetOption(e *mathjax) {
+	e.mergeAdjacentDisplay = o.value
+}
+
+type withStripTeXComments struct {
+	value bool
+}
+
+// WithStripTeXComments removes `%` comments from display math before it is
+// rendered.
+func WithStripTeXComments(value bool) Option {
+	return &withStripTeXComments{value}
+}
+
+func (o *withStripTeXComments) SetOption(e *mathjax) {
+	e.stripTeXComments = o.value
+}
+
+type withDisplayAttribute struct {
+	value bool
+}
+
+// WithDisplayAttribute adds a data-display attribute, "true" for display
+// math and "false" for inline math, to the math span.
+func WithDisplayAttribute(value bool) Option {
+	return &withDisplayAttribute{value}
+}
+
+func (o *withDisplayAttribute) SetOption(e *mathjax) {
+	e.displayAttribute = o.value
+}
+
+type withOnMath struct {
+	fn func(tex []byte, display bool, line int)
+}
+
+// WithOnMath calls fn with the TeX, kind and 1-based source line of every
+// math node as it is rendered. The TeX is the TeX written, canonicalized
+// with WithCanonicalOutput. fn must not retain tex.
+func WithOnMath(fn func(tex []byte, display bool, line int)) Option {
+	return &withOnMath{fn}
+}
+
+func (o *withOnMath) SetOption(e *mathjax) {
+	e.onMath = o.fn
+}
+
+type withFlankingRules struct {
+	value bool
+}
+
+// WithFlankingRules only accepts an opening `$` that is not followed by
+// white space and a closing `$` that is not preceded by white space, like
+// emphasis delimiters.
+func WithFlankingRules(value bool) Option {
+	return &withFlankingRules{value}
+}
+
+func (o *withFlankingRules) SetOption(e *mathjax) {
+	e.flankingRules = o.value
+}
+
+type withInlineLaTeXBrackets struct {
+	value bool
+}
+
+// WithInlineLaTeXBrackets parses `\[...\]` inside paragraphs as display
+// math.
+func WithInlineLaTeXBrackets(value bool) Option {
+	return &withInlineLaTeXBrackets{value}
+}
+
+func (o *withInlineLaTeXBrackets) SetOption(e *mathjax) {
+	e.inlineLaTeXBrackets = o.value
+}
+
+type withPreserveBlockIndent struct {
+	value bool
+}
+
+// WithPreserveBlockIndent keeps the content lines of an indented display
+// block as they are in the source instead of removing the indentation of
+// the opening fence from them.
+func WithPreserveBlockIndent(value bool) Option {
+	return &withPreserveBlockIndent{value}
+}
+
+func (o *withPreserveBlockIndent) SetOption(e *mathjax) {
+	e.preserveBlockIndent = o.value
+}
+
+type withNoTranslate struct {
+	value bool
+}
+
+// WithNoTranslate adds translate="no" to the math span so that translation
+// services leave the TeX alone.
+func WithNoTranslate(value bool) Option {
+	return &withNoTranslate{value}
+}
+
+func (o *withNoTranslate) SetOption(e *mathjax) {
+	e.noTranslate = o.value
+}
+
+// EmptyRenderMode is how WithEmptyRender renders math without content.
+type EmptyRenderMode string
+
+const (
+	// EmptyDelimiters renders empty math like any other math.
+	EmptyDelimiters EmptyRenderMode = "delimiters"
+	// EmptyComment writes an HTML comment in place of empty math.
+	EmptyComment EmptyRenderMode = "comment"
+	// EmptyOmit writes nothing for empty math.
+	EmptyOmit EmptyRenderMode = "omit"
+)
+
+type withEmptyRender struct {
+	mode EmptyRenderMode
+}
+
+// WithEmptyRender sets how math without content is rendered: EmptyDelimiters
+// (the default) renders it like any other math, EmptyComment writes an HTML
+// comment in its place and EmptyOmit writes nothing. Modes are
+// case-insensitive, and any other mode is EmptyDelimiters.
+func WithEmptyRender(mode EmptyRenderMode) Option {
+	return &withEmptyRender{mode}
+}
+
+func (o *withEmptyRender) SetOption(e *mathjax) {
+	switch mode := EmptyRenderMode(strings.ToLower(string(o.mode))); mode {
+	case EmptyComment, EmptyOmit:
+		e.emptyRender = mode
+	default:
+		e.emptyRender = ""
+	}
+}
+
+// writeEmptyMath writes the replacement for empty math in the given
+// WithEmptyRender mode.
+func writeEmptyMath(w util.BufWriter, mode EmptyRenderMode) {
+	if mode == EmptyComment {
+		_, _ = w.WriteString(`<!-- empty math -->`)
+	}
+}
+
+type withDebugAttributes struct {
+	value bool
+}
+
+// WithDebugAttributes adds data-kind, data-line, data-delim and data-rawlen
+// attributes describing how each math node was parsed to its span.
+// data-rawlen is the length in bytes of the TeX in the source, before it
+// is transformed for output.
+func WithDebugAttributes(value bool) Option {
+	return &withDebugAttributes{value}
+}
+
+func (o *withDebugAttributes) SetOption(e *mathjax) {
+	e.debugAttributes = o.value
+}
+
+func writeDebugAttributes(w util.BufWriter, kind string, line int, delim string, rawLen int) {
+	_, _ = w.WriteString(` data-kind="` + kind + `"`)
+	_, _ = w.WriteString(` data-line="` + strconv.Itoa(line) + `"`)
+	_, _ = w.WriteString(` data-delim="`)
+	_, _ = w.Write(util.EscapeHTML([]byte(delim)))
+	_, _ = w.WriteString(`" data-rawlen="` + strconv.Itoa(rawLen) + `"`)
+}
+
+type withCodeWrapper struct {
+	value bool
+}
+
+// WithCodeWrapper wraps math in a <code> element instead of a <span>, for
+// sites that style math with CSS only. The TeX is HTML-escaped, as the
+// content of a code element is text.
+func WithCodeWrapper(value bool) Option {
+	return &withCodeWrapper{value}
+}
+
+func (o *withCodeWrapper) SetOption(e *mathjax) {
+	e.codeWrapper = o.value
+}
+
+// wrapperElement returns the name of the element wrapping math.
+func (e *mathjax) wrapperElement() string {
+	if e.codeWrapper {
+		return "code"
+	}
+	return "span"
+}
+
+// writeWrappedTeX writes tex as the content of the wrapper element.
+func (e *mathjax) writeWrappedTeX(w util.BufWriter, tex []byte) {
+	if e.codeWrapper || e.escapeRawTeX {
+		_, _ = w.Write(util.EscapeHTML(tex))
+		return
+	}
+	_, _ = w.Write(tex)
+}
+
+// rawTeX returns tex for the outputs that otherwise write it unescaped, such
+// as templates and script elements: escaped for RenderMathInHTML, whose TeX
+// is decoded from HTML text.
+func (e *mathjax) rawTeX(tex []byte) []byte {
+	if e.escapeRawTeX {
+		return util.EscapeHTML(tex)
+	}
+	return tex
+}
+
+// escapesTeX reports whether the renderers HTML-escape the TeX they write
+// as the content of math elements, following the precedence of the output
+// options in the renderers.
+func (e *mathjax) escapesTeX() bool {
+	switch {
+	case e.template != nil, e.mathJax3Containers:
+		return false
+	case e.templateElement != "":
+		return true
+	case e.canonicalOutput:
+		return false
+	}
+	return e.codeWrapper
+}
+
+// writesCanonicalTeX reports whether the renderers write the canonicalTeX
+// of math, following the precedence of the output options in the renderers.
+func (e *mathjax) writesCanonicalTeX() bool {
+	return e.canonicalOutput && e.template == nil && !e.mathJax3Containers && e.templateElement == ""
+}
+
+var htmlEscapeKey = parser.NewContextKey()
+
+// HTMLEscapeEnabled reports whether the extension that parsed the document
+// of pc HTML-escapes the TeX of math nodes when rendering them, for custom
+// renderers and transformers that should treat the TeX the same way. The
+// TeX in the noscript and source fallback elements is always escaped.
+func HTMLEscapeEnabled(pc parser.Context) bool {
+	v, _ := pc.Get(htmlEscapeKey).(bool)
+	return v
+}
+
+type withExactFenceLength struct {
+	value bool
+}
+
+// WithExactFenceLength only closes a display block with a fence of as many
+// dollars as the opening fence; other runs of dollars are content.
+func WithExactFenceLength(value bool) Option {
+	return &withExactFenceLength{value}
+}
+
+func (o *withExactFenceLength) SetOption(e *mathjax) {
+	e.exactFenceLength = o.value
+}
+
+type withForceDisplayStyle struct {
+	value bool
+}
+
+// WithForceDisplayStyle prepends `\displaystyle ` to the TeX of display
+// blocks.
+func WithForceDisplayStyle(value bool) Option {
+	return &withForceDisplayStyle{value}
+}
+
+func (o *withForceDisplayStyle) SetOption(e *mathjax) {
+	e.forceDisplayStyle = o.value
+}
+
+type withMaxMathNodes struct {
+	value int
+}
+
+// WithMaxMathNodes limits the number of math nodes of a document to n;
+// further math is left as literal text. Display blocks are parsed before
+// inline math and take their share of the limit first. 0 means no limit.
+func WithMaxMathNodes(n int) Option {
+	return &withMaxMathNodes{n}
+}
+
+func (o *withMaxMathNodes) SetOption(e *mathjax) {
+	e.maxMathNodes = o.value
+}
+
+var mathNodeCountKey = parser.NewContextKey()
+
+// takeMathNode counts a new math node in pc and reports whether it is
+// within the WithMaxMathNodes limit.
+func (e *mathjax) takeMathNode(pc parser.Context) bool {
+	if e.maxMathNodes <= 0 {
+		return true
+	}
+	count, _ := pc.Get(mathNodeCountKey).(int)
+	if count >= e.maxMathNodes {
+		return false
+	}
+	pc.Set(mathNodeCountKey, count+1)
+	return true
+}
+
+type withCloseBeforeDigit struct {
+	value bool
+}
+
+// WithCloseBeforeDigit sets whether a `$` followed by a digit can close
+// inline math, as in `$x$5`. It can by default; with false, `$x$5` is not
+// math, which keeps amounts such as `$5 or $10` apart.
+func WithCloseBeforeDigit(value bool) Option {
+	return &withCloseBeforeDigit{value}
+}
+
+func (o *withCloseBeforeDigit) SetOption(e *mathjax) {
+	e.noCloseBeforeDigit = !o.value
+}
+
+type withOpenAfterDigit struct {
+	value bool
+}
+
+// WithOpenAfterDigit sets whether a `$` preceded by a digit can open inline
+// math, as in `2$x$`. It can by default; with false, `2$x$` is not math.
+func WithOpenAfterDigit(value bool) Option {
+	return &withOpenAfterDigit{value}
+}
+
+func (o *withOpenAfterDigit) SetOption(e *mathjax) {
+	e.noOpenAfterDigit = !o.value
+}
+
+type withNestedFenceEscape struct {
+	value bool
+}
+
+// WithNestedFenceEscape lets a backslash escape a closing fence, so that
+// `\$$` inside a display block is content rather than the end of the
+// block. The backslash is kept.
+func WithNestedFenceEscape(value bool) Option {
+	return &withNestedFenceEscape{value}
+}
+
+func (o *withNestedFenceEscape) SetOption(e *mathjax) {
+	e.nestedFenceEscape = o.value
+}
+
+type withMergeSeparator struct {
+	sep string
+}
+
+// WithMergeSeparator sets the TeX written between the rows of display
+// blocks merged by WithMergeAdjacentDisplay, `\\` by default.
+func WithMergeSeparator(sep string) Option {
+	return &withMergeSeparator{sep}
+}
+
+func (o *withMergeSeparator) SetOption(e *mathjax) {
+	e.mergeSeparator = o.sep
+}
+
+// NumberingMode is how WithNumberingMode renders equation numbers.
+type NumberingMode string
+
+const (
+	// NumberingTag adds the number to the TeX as `\tag{n}`.
+	NumberingTag NumberingMode = "tag"
+	// NumberingDOM writes the number after the math span.
+	NumberingDOM NumberingMode = "dom"
+)
+
+type withNumberingMode struct {
+	mode NumberingMode
+}
+
+// WithNumberingMode renders the numbers of display equations. With
+// NumberingTag the number is added to the TeX as `\tag{n}` for MathJax to
+// render; with NumberingDOM it is written after the math span as
+// `<span class="eqno">(n)</span>`. Modes are case-insensitive, and any other
+// mode leaves equations unnumbered. Equations are numbered in document
+// order, as by NewEquationIndexTransformer, which skips equations with their
+// own \tag, \notag or \nonumber.
+func WithNumberingMode(mode NumberingMode) Option {
+	return &withNumberingMode{mode}
+}
+
+func (o *withNumberingMode) SetOption(e *mathjax) {
+	switch mode := NumberingMode(strings.ToLower(string(o.mode))); mode {
+	case NumberingTag, NumberingDOM:
+		e.numberingMode = mode
+	default:
+		e.numberingMode = ""
+	}
+}
+
+// FinalNewlineMode is how WithFinalNewline writes the newline ending the TeX
+// of a display block.
+type FinalNewlineMode string
+
+const (
+	// FinalNewlineKeep writes the newline as is.
+	FinalNewlineKeep FinalNewlineMode = "keep"
+	// FinalNewlineDrop removes the newline.
+	FinalNewlineDrop FinalNewlineMode = "drop"
+	// FinalNewlineSpace replaces the newline with a space.
+	FinalNewlineSpace FinalNewlineMode = "space"
+)
+
+type withFinalNewline struct {
+	mode FinalNewlineMode
+}
+
+// WithFinalNewline sets how the newline ending the TeX of a multi-line
+// display block is written: FinalNewlineKeep (default), FinalNewlineDrop,
+// or FinalNewlineSpace to replace it with a space. Modes are
+// case-insensitive, and any other mode is FinalNewlineKeep.
+func WithFinalNewline(mode FinalNewlineMode) Option {
+	return &withFinalNewline{mode}
+}
+
+func (o *withFinalNewline) SetOption(e *mathjax) {
+	switch mode := FinalNewlineMode(strings.ToLower(string(o.mode))); mode {
+	case FinalNewlineDrop, FinalNewlineSpace:
+		e.finalNewline = mode
+	default:
+		e.finalNewline = ""
+	}
+}
+
+type withMathFence struct {
+	language string
+}
+
+// WithMathFence renders fenced code blocks with info string language, such
+// as "math", as display math. The blocks are replaced in the AST, so they
+// take precedence over renderers of fenced code blocks such as syntax
+// highlighters.
+func WithMathFence(language string) Option {
+	return &withMathFence{language}
+}
+
+func (o *withMathFence) SetOption(e *mathjax) {
+	e.mathFence = o.language
+}
+
+// BlankLineMode is what WithBlankLineInBlock makes a blank line inside a
+// display block do.
+type BlankLineMode string
+
+const (
+	// BlankLineInclude keeps the blank line as content.
+	BlankLineInclude BlankLineMode = "include"
+	// BlankLineTerminate ends the block at the blank line.
+	BlankLineTerminate BlankLineMode = "terminate"
+)
+
+type withBlankLineInBlock struct {
+	mode BlankLineMode
+}
+
+// WithBlankLineInBlock sets what a blank line inside a multi-line display
+// block does: with BlankLineInclude (default) it is content, and with
+// BlankLineTerminate it ends the block as if the document ended there.
+// Modes are case-insensitive, and any other mode is BlankLineInclude.
+func WithBlankLineInBlock(mode BlankLineMode) Option {
+	return &withBlankLineInBlock{mode}
+}
+
+func (o *withBlankLineInBlock) SetOption(e *mathjax) {
+	switch mode := BlankLineMode(strings.ToLower(string(o.mode))); mode {
+	case BlankLineTerminate:
+		e.blankLineInBlock = mode
+	default:
+		e.blankLineInBlock = ""
+	}
+}
+
+type withTitleSource struct {
+	value bool
+}
+
+// WithTitleSource adds the source of each math node, delimiters included,
+// as the title attribute of its span, so that it shows as a tooltip.
+func WithTitleSource(value bool) Option {
+	return &withTitleSource{value}
+}
+
+func (o *withTitleSource) SetOption(e *mathjax) {
+	e.titleSource = o.value
+}
+
+// writeTitleSource writes source[start:end] as an escaped title attribute,
+// unless the range is empty, as for nodes that were not parsed.
+func writeTitleSource(w util.BufWriter, source []byte, start, end int) {
+	if start >= end || end > len(source) {
+		return
+	}
+	_, _ = w.WriteString(` title="`)
+	_, _ = w.Write(util.EscapeHTML(source[start:end]))
+	_, _ = w.WriteString(`"`)
+}
+
+type withBlockBlankLineAbandon struct {
+	value bool
+}
+
+// WithBlockBlankLineAbandon makes a `$$` fence whose block is not closed
+// before the next blank line or the end of the document not open display
+// math. The lines are parsed as usual instead, so that inline math in them
+// still renders.
+func WithBlockBlankLineAbandon(value bool) Option {
+	return &withBlockBlankLineAbandon{value}
+}
+
+func (o *withBlockBlankLineAbandon) SetOption(e *mathjax) {
+	e.blockBlankLineAbandon = o.value
+}
+
+type withMaxBlockLines struct {
+	max int
+}
+
+// WithMaxBlockLines makes a `$$` fence whose display block, fences included,
+// would span more than max lines not open display math. Its lines are
+// parsed as usual instead. Zero means no limit.
+func WithMaxBlockLines(max int) Option {
+	return &withMaxBlockLines{max}
+}
+
+func (o *withMaxBlockLines) SetOption(e *mathjax) {
+	e.maxBlockLines = o.max
+}
+
+type withAutoIDs struct {
+	prefix string
+}
+
+// WithAutoIDs gives the span of every math node the id prefix followed by
+// the Index of the node, e.g. `math-0`, `math-1` for prefix "math-", so that
+// any equation can be linked to. An id from an attribute list takes
+// precedence.
+func WithAutoIDs(prefix string) Option {
+	return &withAutoIDs{prefix}
+}
+
+func (o *withAutoIDs) SetOption(e *mathjax) {
+	e.autoIDPrefix = o.prefix
+}
+
+func writeAutoID(w util.BufWriter, prefix string, index int) {
+	_, _ = w.WriteString(` id="`)
+	_, _ = w.Write(util.EscapeHTML([]byte(prefix + strconv.Itoa(index))))
+	_, _ = w.WriteString(`"`)
+}
+
+type withDir struct {
+	dir string
+}
+
+// WithDir sets the dir attribute of math spans, e.g. "ltr" so that math
+// inside right-to-left text is not mirrored.
+func WithDir(dir string) Option {
+	return &withDir{dir}
+}
+
+func (o *withDir) SetOption(e *mathjax) {
+	e.dir = o.dir
+}
+
+type withAccessibleBlocks struct {
+	value bool
+}
+
+// WithAccessibleBlocks writes display blocks as a
+// `<div role="math" aria-label="..." class="math display">` labelled with
+// their escaped TeX for screen readers, instead of a span in a paragraph.
+func WithAccessibleBlocks(value bool) Option {
+	return &withAccessibleBlocks{value}
+}
+
+func (o *withAccessibleBlocks) SetOption(e *mathjax) {
+	e.accessibleBlocks = o.value
+}
+
+type withInlineDisplayStyle struct {
+	value bool
+}
+
+// WithInlineDisplayStyle renders `$$...$$` inside a paragraph as inline math
+// in display style, prepending `\displaystyle ` to its TeX, instead of as
+// plain inline math.
+func WithInlineDisplayStyle(value bool) Option {
+	return &withInlineDisplayStyle{value}
+}
+
+func (o *withInlineDisplayStyle) SetOption(e *mathjax) {
+	e.inlineDisplayStyle = o.value
+}
+
+type withEquationNumberFunc struct {
+	fn func(seq int) string
+}
+
+// WithEquationNumberFunc formats the numbers written by WithNumberingMode
+// with fn, which receives the 1-based sequential number of the equation,
+// e.g. to prefix a chapter number as in "4.1". The result is written
+// without parentheses.
+func WithEquationNumberFunc(fn func(seq int) string) Option {
+	return &withEquationNumberFunc{fn}
+}
+
+func (o *withEquationNumberFunc) SetOption(e *mathjax) {
+	e.equationNumberFunc = o.fn
+}
+
+// equationNumber returns the text of the equation number seq.
+func (e *mathjax) equationNumber(seq int) string {
+	if e.equationNumberFunc != nil {
+		return e.equationNumberFunc(seq)
+	}
+	return strconv.Itoa(seq)
+}
+
 var MathJax = &mathjax{
 	inlineStartDelim: `\(`,
 	inlineEndDelim:   `\)`,
@@ -67,15 +1013,67 @@ func NewMathJax(opts ...Option) *mathjax {
 	return r
 }
 
+// NewExtension returns the extension configured with opts, the same as
+// NewMathJax but typed as a goldmark.Extender.
+func NewExtension(opts ...Option) goldmark.Extender {
+	return NewMathJax(opts...)
+}
+
 func (e *mathjax) Extend(m goldmark.Markdown) {
+	blockPriority := 701
+	if e.indentedBlocks {
+		// run before the indented code block parser (500)
+		blockPriority = 499
+	}
 	m.Parser().AddOptions(parser.WithBlockParsers(
-		util.Prioritized(NewMathJaxBlockParser(), 701),
+		util.Prioritized(&mathJaxBlockParser{e}, blockPriority),
 	))
+	if e.containerFence != "" {
+		m.Parser().AddOptions(parser.WithBlockParsers(
+			util.Prioritized(&containerFenceParser{e}, blockPriority+1),
+		))
+	}
+	if e.amsEnvironments {
+		m.Parser().AddOptions(parser.WithBlockParsers(
+			util.Prioritized(&environmentBlockParser{e}, blockPriority+1),
+		))
+	}
 	m.Parser().AddOptions(parser.WithInlineParsers(
-		util.Prioritized(NewInlineMathParser(), 501),
+		util.Prioritized(&inlineMathParser{e}, 501),
+	))
+	if e.inlineLaTeXBrackets {
+		m.Parser().AddOptions(parser.WithInlineParsers(
+			util.Prioritized(&bracketMathParser{e}, 501),
+		))
+	}
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&mathTransformer{e}, 501),
 	))
+	if e.numberingMode != "" {
+		// number the blocks left after merging
+		m.Parser().AddOptions(parser.WithASTTransformers(
+			util.Prioritized(NewEquationIndexTransformer(), 502),
+		))
+	}
 	m.Renderer().AddOptions(renderer.WithNodeRenderers(
-		util.Prioritized(NewMathBlockRenderer(e.blockStartDelim, e.blockEndDelim), 501),
-		util.Prioritized(NewInlineMathRenderer(e.inlineStartDelim, e.inlineEndDelim), 502),
+		util.Prioritized(&MathBlockRenderer{e.blockStartDelim, e.blockEndDelim, e}, 501),
+		util.Prioritized(&InlineMathRenderer{e.inlineStartDelim, e.inlineEndDelim, e}, 502),
+		util.Prioritized(NewTeXLiteralRenderer(), 503),
+		util.Prioritized(&strictErrorRenderer{}, 504),
 	))
+	if e.mathInRawHTML {
+		m.Renderer().AddOptions(renderer.WithNodeRenderers(
+			util.Prioritized(&rawHTMLRenderer{html.NewConfig(), e}, 505),
+		))
+	}
+}
+
+// RegisterRenderers registers the math node renderers configured with opts,
+// without the parsers, e.g. from the RegisterFuncs method of a node renderer
+// for documents whose math nodes were built elsewhere.
+func RegisterRenderers(reg renderer.NodeRendererFuncRegisterer, opts ...Option) {
+	e := NewMathJax(opts...)
+	(&MathBlockRenderer{e.blockStartDelim, e.blockEndDelim, e}).RegisterFuncs(reg)
+	(&InlineMathRenderer{e.inlineStartDelim, e.inlineEndDelim, e}).RegisterFuncs(reg)
+	NewTeXLiteralRenderer().RegisterFuncs(reg)
 }
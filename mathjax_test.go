@@ -2,11 +2,21 @@ package mathjax
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 	"testing"
+	"text/template"
 
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,118 +27,273 @@ type mathJaxTestCase struct {
 	out string // expected output html
 }
 
-func TestMathJax(t *testing.T) {
-
-	tests := []mathJaxTestCase{
-		{
-			d:   "plain text",
-			in:  "foo",
-			out: `<p>foo</p>`,
-		},
-		{
-			d:   "bold",
-			in:  "**foo**",
-			out: `<p><strong>foo</strong></p>`,
-		},
-		{
-			d:   "math inline",
-			in:  "$1+2$",
-			out: `<p><span class="math inline">\(1+2\)</span></p>`,
-		},
-		{
-			d:  "math display",
-			in: "$$\n1+2\n$$",
-			out: `<p><span class="math display">\[1+2
+// mathJaxTests are the main rendering test cases; they also seed FuzzConvert.
+var mathJaxTests = []mathJaxTestCase{
+	{
+		d:   "plain text",
+		in:  "foo",
+		out: `<p>foo</p>`,
+	},
+	{
+		d:   "bold",
+		in:  "**foo**",
+		out: `<p><strong>foo</strong></p>`,
+	},
+	{
+		d:   "math inline",
+		in:  "$1+2$",
+		out: `<p><span class="math inline">\(1+2\)</span></p>`,
+	},
+	{
+		d:  "math display",
+		in: "$$\n1+2\n$$",
+		out: `<p><span class="math display">\[1+2
 \]</span></p>`,
-		},
-		{
-			// this input previously triggered a panic in block.go
-			d:   "list-begin",
-			in:  "*foo\n  ",
-			out: "<p>*foo</p>",
-		},
-		// Same-line format tests
-		{
-			d:   "math display - same line simple",
-			in:  `$$x+y$$`,
-			out: `<p><span class="math display">\[x+y\]</span></p>`,
-		},
-		{
-			d:   "math display - same line complex",
-			in:  `$$\iint^{\infty}_{0}{xdxdy}$$`,
-			out: `<p><span class="math display">\[\iint^{\infty}_{0}{xdxdy}\]</span></p>`,
-		},
-		{
-			d:   "math display - same line with spaces",
-			in:  `$$  a + b  $$`,
-			out: `<p><span class="math display">\[  a + b  \]</span></p>`,
-		},
-		{
-			d:   "math display - same line empty",
-			in:  `$$$$`,
-			out: `<p><span class="math display">\[\]</span></p>`,
-		},
-		// Consecutive blocks tests
-		{
-			d:  "math display - two same-line blocks",
-			in: "$$x+y$$\n$$a+b$$",
-			out: `<p><span class="math display">\[x+y\]</span></p>
+	},
+	{
+		// this input previously triggered a panic in block.go
+		d:   "list-begin",
+		in:  "*foo\n  ",
+		out: "<p>*foo</p>",
+	},
+	// Leading punctuation tests
+	{
+		d:   "math inline - after double quote",
+		in:  `"$x$"`,
+		out: `<p>&quot;<span class="math inline">\(x\)</span>&quot;</p>`,
+	},
+	{
+		d:   "math inline - after single quote",
+		in:  `'$x$'`,
+		out: `<p>'<span class="math inline">\(x\)</span>'</p>`,
+	},
+	{
+		d:   "math inline - after parenthesis",
+		in:  `($x$)`,
+		out: `<p>(<span class="math inline">\(x\)</span>)</p>`,
+	},
+	{
+		d:   "math inline - after bracket",
+		in:  `[$x$]`,
+		out: `<p>[<span class="math inline">\(x\)</span>]</p>`,
+	},
+	{
+		d:   "math inline - after brace",
+		in:  `{$x$}`,
+		out: `<p>{<span class="math inline">\(x\)</span>}</p>`,
+	},
+	{
+		d:   "math inline - inside link text",
+		in:  `[$x$](http://example.com)`,
+		out: `<p><a href="http://example.com"><span class="math inline">\(x\)</span></a></p>`,
+	},
+	{
+		d:   "math inline - two spans separated by a space",
+		in:  `$x$ $y$`,
+		out: `<p><span class="math inline">\(x\)</span> <span class="math inline">\(y\)</span></p>`,
+	},
+	{
+		d:   "math inline - followed by double-dollar math",
+		in:  `$a$ $$b$$`,
+		out: `<p><span class="math inline">\(a\)</span> <span class="math inline">\(b\)</span></p>`,
+	},
+	{
+		d:  "math display - empty fence pair does not open a block",
+		in: "a\n\n$$$$\n\nb",
+		out: `<p>a</p>
+<p><span class="math display">\[\]</span></p>
+<p>b</p>`,
+	},
+	// Dollars inside text groups and escaped dollars
+	{
+		d:   "math inline - escaped dollar in text group",
+		in:  `$\text{price is \$5}$`,
+		out: `<p><span class="math inline">\(\text{price is \$5}\)</span></p>`,
+	},
+	{
+		d:   "math inline - nested math in text group",
+		in:  `$\text{if $x>0$ then} y$ after`,
+		out: `<p><span class="math inline">\(\text{if $x>0$ then} y\)</span> after</p>`,
+	},
+	{
+		d:   "math inline - nested braces in text group",
+		in:  `$\textbf{a {b} $c$} + d$`,
+		out: `<p><span class="math inline">\(\textbf{a {b} $c$} + d\)</span></p>`,
+	},
+	{
+		d:   "math inline - mbox group",
+		in:  `$\mbox{$a$} = b$`,
+		out: `<p><span class="math inline">\(\mbox{$a$} = b\)</span></p>`,
+	},
+	{
+		d:   "math inline - escaped dollar outside text group",
+		in:  `$a \$ b$`,
+		out: `<p><span class="math inline">\(a \$ b\)</span></p>`,
+	},
+	{
+		d:   "math inline - closes after text group",
+		in:  `$\text{a}$ and $b$`,
+		out: `<p><span class="math inline">\(\text{a}\)</span> and <span class="math inline">\(b\)</span></p>`,
+	},
+	// Multibyte content tests
+	{
+		d:   "math inline - greek letters",
+		in:  `$α+β=γ$`,
+		out: `<p><span class="math inline">\(α+β=γ\)</span></p>`,
+	},
+	{
+		d:   "math inline - emoji",
+		in:  `$\text{😀} x$ and $ é $`,
+		out: `<p><span class="math inline">\(\text{😀} x\)</span> and <span class="math inline">\(é\)</span></p>`,
+	},
+	{
+		d:   "math display - greek letters",
+		in:  `$$∑_{i} α_i$$`,
+		out: `<p><span class="math display">\[∑_{i} α_i\]</span></p>`,
+	},
+	// Same-line format tests
+	{
+		d:   "math display - same line simple",
+		in:  `$$x+y$$`,
+		out: `<p><span class="math display">\[x+y\]</span></p>`,
+	},
+	{
+		d:   "math display - same line complex",
+		in:  `$$\iint^{\infty}_{0}{xdxdy}$$`,
+		out: `<p><span class="math display">\[\iint^{\infty}_{0}{xdxdy}\]</span></p>`,
+	},
+	{
+		d:   "math display - same line with spaces",
+		in:  `$$  a + b  $$`,
+		out: `<p><span class="math display">\[  a + b  \]</span></p>`,
+	},
+	{
+		d:   "math display - same line empty",
+		in:  `$$$$`,
+		out: `<p><span class="math display">\[\]</span></p>`,
+	},
+	// Trailing whitespace after the closing fence
+	{
+		d:   "math display - same line trailing spaces",
+		in:  "$$x$$   ",
+		out: `<p><span class="math display">\[x\]</span></p>`,
+	},
+	{
+		d:   "math display - same line trailing tab",
+		in:  "$$x$$\t",
+		out: `<p><span class="math display">\[x\]</span></p>`,
+	},
+	{
+		d:   "math display - same line trailing spaces and CRLF",
+		in:  "$$x$$  \r\n",
+		out: `<p><span class="math display">\[x\]</span></p>`,
+	},
+	{
+		d:  "math display - same line CRLF then text",
+		in: "$$x$$\r\ntext",
+		out: `<p><span class="math display">\[x\]</span></p>
+<p>text</p>`,
+	},
+	{
+		d:  "math display - multi-line closing fence with trailing tab",
+		in: "$$\nx\n$$ \t\ntext",
+		out: `<p><span class="math display">\[x
+\]</span></p>
+<p>text</p>`,
+	},
+	// Consecutive blocks tests
+	{
+		d:  "math display - two same-line blocks",
+		in: "$$x+y$$\n$$a+b$$",
+		out: `<p><span class="math display">\[x+y\]</span></p>
 <p><span class="math display">\[a+b\]</span></p>`,
-		},
-		{
-			d:  "math display - two same-line blocks with blank line",
-			in: "$$x+y$$\n\n$$a+b$$",
-			out: `<p><span class="math display">\[x+y\]</span></p>
+	},
+	{
+		d:  "math display - two same-line blocks with blank line",
+		in: "$$x+y$$\n\n$$a+b$$",
+		out: `<p><span class="math display">\[x+y\]</span></p>
 <p><span class="math display">\[a+b\]</span></p>`,
-		},
-		{
-			d:  "math display - consecutive multi-line blocks with blank line",
-			in: "$$\n1+2\n$$\n\n$$\n3+4\n$$",
-			out: `<p><span class="math display">\[1+2
+	},
+	{
+		d:  "math display - consecutive multi-line blocks with blank line",
+		in: "$$\n1+2\n$$\n\n$$\n3+4\n$$",
+		out: `<p><span class="math display">\[1+2
 \]</span></p>
 <p><span class="math display">\[3+4
 \]</span></p>`,
-		},
-		// Mixed format tests
-		{
-			d:  "math display - multi-line then same-line",
-			in: "$$\nx+y\n$$\n$$a+b$$",
-			out: `<p><span class="math display">\[x+y
+	},
+	// Mixed format tests
+	{
+		d:  "math display - multi-line then same-line",
+		in: "$$\nx+y\n$$\n$$a+b$$",
+		out: `<p><span class="math display">\[x+y
 \]</span></p>
 <p><span class="math display">\[a+b\]</span></p>`,
-		},
-		// Multi-line with content variations
-		{
-			d:  "math display - multi-line multiple lines content",
-			in: "$$\na+b\\\\\nc+d\n$$",
-			out: `<p><span class="math display">\[a+b\\
+	},
+	// Multi-line with content variations
+	{
+		d:  "math display - multi-line multiple lines content",
+		in: "$$\na+b\\\\\nc+d\n$$",
+		out: `<p><span class="math display">\[a+b\\
 c+d
 \]</span></p>`,
-		},
-		// Text mixing tests
-		{
-			d:  "math display - text before same-line",
-			in: "text before\n$$x+y$$",
-			out: `<p>text before</p>
+	},
+	// Content before the closing fence of an indented block
+	{
+		d:   "math display - indented block with content before close",
+		in:  "  $$\n  a + b $$",
+		out: `<p><span class="math display">\[a + b \]</span></p>`,
+	},
+	{
+		d:   "math display - indented block keeps extra indentation before close",
+		in:  "  $$\n    a+b $$",
+		out: `<p><span class="math display">\[  a+b \]</span></p>`,
+	},
+	{
+		d:   "math display - closing line less indented than the fence",
+		in:  "  $$\n a $$",
+		out: `<p><span class="math display">\[a \]</span></p>`,
+	},
+	{
+		d:   "math display - closing line not indented",
+		in:  "  $$\nab$$",
+		out: `<p><span class="math display">\[ab\]</span></p>`,
+	},
+	{
+		d:   "math display - tab indented closing line",
+		in:  "  $$\n\tab $$",
+		out: `<p><span class="math display">\[  ab \]</span></p>`,
+	},
+	{
+		d:  "math display - indented multi-line block with content before close",
+		in: "  $$\n  x\n  y $$",
+		out: `<p><span class="math display">\[x
+y \]</span></p>`,
+	},
+	// Text mixing tests
+	{
+		d:  "math display - text before same-line",
+		in: "text before\n$$x+y$$",
+		out: `<p>text before</p>
 <p><span class="math display">\[x+y\]</span></p>`,
-		},
-		{
-			d:  "math display - text after same-line",
-			in: "$$x+y$$\ntext after",
-			out: `<p><span class="math display">\[x+y\]</span></p>
+	},
+	{
+		d:  "math display - text after same-line",
+		in: "$$x+y$$\ntext after",
+		out: `<p><span class="math display">\[x+y\]</span></p>
 <p>text after</p>`,
-		},
-		{
-			d:  "math display - text before and after same-line",
-			in: "before\n$$x+y$$\nafter",
-			out: `<p>before</p>
+	},
+	{
+		d:  "math display - text before and after same-line",
+		in: "before\n$$x+y$$\nafter",
+		out: `<p>before</p>
 <p><span class="math display">\[x+y\]</span></p>
 <p>after</p>`,
-		},
-		// vmatrix test - bug report case
-		{
-			d: "math display - vmatrix multiline",
-			in: `Before matrix
+	},
+	// vmatrix test - bug report case
+	{
+		d: "math display - vmatrix multiline",
+		in: `Before matrix
 
 $$\begin{vmatrix}
 \vec{i} & \vec{j} & \vec{k} \\
@@ -137,18 +302,18 @@ $$\begin{vmatrix}
 \end{vmatrix}$$
 
 After matrix`,
-			out: `<p>Before matrix</p>
+		out: `<p>Before matrix</p>
 <p><span class="math display">\[\begin{vmatrix}
 \vec{i} & \vec{j} & \vec{k} \\
 1 & 2 & 3 \\
 4 & 5 & 6
 \end{vmatrix}\]</span></p>
 <p>After matrix</p>`,
-		},
-		// pmatrix test
-		{
-			d: "math display - pmatrix multiline",
-			in: `Before matrix
+	},
+	// pmatrix test
+	{
+		d: "math display - pmatrix multiline",
+		in: `Before matrix
 
 $$\begin{pmatrix}
 1 & 2 \\
@@ -156,16 +321,17 @@ $$\begin{pmatrix}
 \end{pmatrix}$$
 
 After matrix`,
-			out: `<p>Before matrix</p>
+		out: `<p>Before matrix</p>
 <p><span class="math display">\[\begin{pmatrix}
 1 & 2 \\
 3 & 4
 \end{pmatrix}\]</span></p>
 <p>After matrix</p>`,
-		},
-	}
+	},
+}
 
-	for i, tc := range tests {
+func TestMathJax(t *testing.T) {
+	for i, tc := range mathJaxTests {
 		t.Run(fmt.Sprintf("%d: %s", i, tc.d), func(t *testing.T) {
 			out, err := renderMarkdown([]byte(tc.in))
 			if err != nil {
@@ -178,8 +344,12 @@ After matrix`,
 }
 
 func renderMarkdown(src []byte) ([]byte, error) {
+	return renderMarkdownWith(src, MathJax)
+}
+
+func renderMarkdownWith(src []byte, extensions ...goldmark.Extender) ([]byte, error) {
 	md := goldmark.New(
-		goldmark.WithExtensions(MathJax),
+		goldmark.WithExtensions(extensions...),
 	)
 
 	var buf bytes.Buffer
@@ -189,3 +359,3131 @@ func renderMarkdown(src []byte) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+func runMathJaxTests(t *testing.T, tests []mathJaxTestCase, extensions ...goldmark.Extender) {
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.d), func(t *testing.T) {
+			out, err := renderMarkdownWith([]byte(tc.in), extensions...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.out, strings.TrimSpace(string(out)))
+		})
+	}
+}
+
+func TestDegenerateBlockInputs(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "single dollar",
+			in:  "$",
+			out: `<p>$</p>`,
+		},
+		{
+			d:   "single dollar and newline",
+			in:  "$\n",
+			out: `<p>$</p>`,
+		},
+		{
+			d:   "bare opening fence",
+			in:  "$$",
+			out: `<p><span class="math display">\[\]</span></p>`,
+		},
+		{
+			d:   "opening fence then single dollar",
+			in:  "$$\n$",
+			out: `<p><span class="math display">\[$\]</span></p>`,
+		},
+		{
+			// this input previously triggered a panic in block.go
+			d:  "tab padded fence in blockquote",
+			in: " >\t$$x",
+			out: `<blockquote>
+<p><span class="math display">\[x\]</span></p>
+</blockquote>`,
+		},
+		{
+			// the closing fence previously opened a second block
+			d:  "tab padded closing fence in blockquote",
+			in: ">\t$$\n>\tx\n>\t$$",
+			out: `<blockquote>
+<p><span class="math display">\[x
+\]</span></p>
+</blockquote>`,
+		},
+	}, MathJax)
+}
+
+// TestNoPanicOnShortInputs renders every short combination of characters
+// that are significant to the block and inline parsers.
+func TestNoPanicOnShortInputs(t *testing.T) {
+	alphabet := []byte("$ \n>\t-x")
+	extensions := []goldmark.Extender{
+		MathJax,
+		NewMathJax(WithIndentedBlocks(true), WithDefinitions(true), WithMaxInlineLength(2)),
+	}
+	var walk func(prefix []byte)
+	walk = func(prefix []byte) {
+		for _, e := range extensions {
+			if _, err := renderMarkdownWith(prefix, e); err != nil {
+				t.Fatalf("%q: %v", prefix, err)
+			}
+		}
+		if len(prefix) == 5 {
+			return
+		}
+		for _, c := range alphabet {
+			walk(append(prefix[:len(prefix):len(prefix)], c))
+		}
+	}
+	walk(nil)
+}
+
+func TestHeadings(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "ATX heading",
+			in:  `## Energy $E=mc^2$`,
+			out: `<h2>Energy <span class="math inline">\(E=mc^2\)</span></h2>`,
+		},
+		{
+			d:   "ATX heading with closing sequence",
+			in:  `# $x$ #`,
+			out: `<h1><span class="math inline">\(x\)</span></h1>`,
+		},
+		{
+			d:   "ATX heading with hash inside math",
+			in:  `# a $x # y$`,
+			out: `<h1>a <span class="math inline">\(x # y\)</span></h1>`,
+		},
+		{
+			d:   "setext heading level 1",
+			in:  "Energy $E=mc^2$\n===",
+			out: `<h1>Energy <span class="math inline">\(E=mc^2\)</span></h1>`,
+		},
+		{
+			d:   "setext heading level 2",
+			in:  "Energy $E=mc^2$\n---",
+			out: `<h2>Energy <span class="math inline">\(E=mc^2\)</span></h2>`,
+		},
+	}, MathJax)
+}
+
+func TestIndentedBlocks(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "indented block is code by default",
+			in:  "    $$\n    x\n    $$",
+			out: "<pre><code>$$\nx\n$$</code></pre>",
+		},
+	}, MathJax)
+
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "indented multi-line block",
+			in: "    $$\n    x\n    $$",
+			out: `<p><span class="math display">\[x
+\]</span></p>`,
+		},
+		{
+			d:   "indented same-line block",
+			in:  "    $$x+y$$",
+			out: `<p><span class="math display">\[x+y\]</span></p>`,
+		},
+		{
+			d:  "indented block keeps extra indentation",
+			in: "    $$\n      x\n    $$\ntext",
+			out: `<p><span class="math display">\[  x
+\]</span></p>
+<p>text</p>`,
+		},
+		{
+			d:   "indented code still works",
+			in:  "    code",
+			out: "<pre><code>code</code></pre>",
+		},
+	}, NewMathJax(WithIndentedBlocks(true)))
+}
+
+func TestDefinitions(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "marker is content by default",
+			in:  `$$!def x = 1$$`,
+			out: `<p><span class="math display">\[!def x = 1\]</span></p>`,
+		},
+	}, MathJax)
+
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "same-line definition",
+			in:  `$$!def x = 1$$`,
+			out: `<p><span class="math display math-def">\[x = 1\]</span></p>`,
+		},
+		{
+			d:  "multi-line definition",
+			in: "$$!def\nf(x) = x^2\n$$",
+			out: `<p><span class="math display math-def">\[f(x) = x^2
+\]</span></p>`,
+		},
+		{
+			d:   "unmarked block",
+			in:  `$$x = 1$$`,
+			out: `<p><span class="math display">\[x = 1\]</span></p>`,
+		},
+		{
+			d:   "marker must be followed by a space",
+			in:  `$$!define$$`,
+			out: `<p><span class="math display">\[!define\]</span></p>`,
+		},
+	}, NewMathJax(WithDefinitions(true)))
+}
+
+func TestTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("math").Parse(
+		`<math-field display="{{.Display}}" data-line="{{.Line}}" data-index="{{.Index}}">{{html .TeX}}</math-field>`,
+	))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  `$a<b$`,
+			out: `<p><math-field display="false" data-line="1" data-index="0">a&lt;b</math-field></p>`,
+		},
+		{
+			d:   "display",
+			in:  `$$x+y$$`,
+			out: `<math-field display="true" data-line="1" data-index="0">x+y</math-field>`,
+		},
+		{
+			d:  "mixed document",
+			in: "$a$ and $b$\n\n$$\nc\n$$\n\n$d$",
+			out: `<p><math-field display="false" data-line="1" data-index="0">a</math-field> and <math-field display="false" data-line="1" data-index="1">b</math-field></p>
+<math-field display="true" data-line="3" data-index="2">c
+</math-field><p><math-field display="false" data-line="7" data-index="3">d</math-field></p>`,
+		},
+	}, NewMathJax(WithTemplate(tmpl)))
+}
+
+func TestLiteralFence(t *testing.T) {
+	in := "Write inline math as:\n\n```tex\n$x < y$ and $$\\frac{a}{b}$$\n```\n\n$z$"
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "fence is a code block by default",
+			in: in,
+			out: `<p>Write inline math as:</p>
+<pre><code class="language-tex">$x &lt; y$ and $$\frac{a}{b}$$
+</code></pre>
+<p><span class="math inline">\(z\)</span></p>`,
+		},
+	}, MathJax)
+
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "literal fence",
+			in: in,
+			out: `<p>Write inline math as:</p>
+<pre class="tex-literal"><code>$x &lt; y$ and $$\frac{a}{b}$$
+</code></pre>
+<p><span class="math inline">\(z\)</span></p>`,
+		},
+		{
+			d:   "other languages are untouched",
+			in:  "```go\nx := \"$a$\"\n```",
+			out: "<pre><code class=\"language-go\">x := &quot;$a$&quot;\n</code></pre>",
+		},
+	}, NewMathJax(WithLiteralFence("tex")))
+}
+
+func TestMaxInlineLength(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "ascii within limit",
+			in:  `$abc$`,
+			out: `<p><span class="math inline">\(abc\)</span></p>`,
+		},
+		{
+			d:   "ascii over limit",
+			in:  `$abcd$`,
+			out: `<p>$abcd$</p>`,
+		},
+		{
+			d:   "greek counts characters, not bytes",
+			in:  `$αβγ$`,
+			out: `<p><span class="math inline">\(αβγ\)</span></p>`,
+		},
+		{
+			d:   "emoji counts characters, not bytes",
+			in:  `$😀+😀$`,
+			out: `<p><span class="math inline">\(😀+😀\)</span></p>`,
+		},
+		{
+			d:   "multibyte over limit",
+			in:  `$αβγδ$ and $x$`,
+			out: `<p>$αβγδ$ and <span class="math inline">\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithMaxInlineLength(3)))
+}
+
+func TestNoScriptFallback(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  `$x^2$`,
+			out: `<p><span class="math inline"><noscript>x^2</noscript>\(x^2\)</span></p>`,
+		},
+		{
+			d:   "inline fallback is escaped",
+			in:  `$a<b & c>d$`,
+			out: `<p><span class="math inline"><noscript>a&lt;b &amp; c&gt;d</noscript>\(a<b & c>d\)</span></p>`,
+		},
+		{
+			d:   "display is unchanged",
+			in:  `$$x$$`,
+			out: `<p><span class="math display">\[x\]</span></p>`,
+		},
+	}, NewMathJax(WithNoScriptFallback(true)))
+}
+
+func TestBlockAlignment(t *testing.T) {
+	for _, tc := range []struct {
+		alignment Alignment
+		out       string
+	}{
+		{AlignLeft, `<p style="text-align:left"><span class="math display">\[x\]</span></p>`},
+		{AlignCenter, `<p><span class="math display">\[x\]</span></p>`},
+		{AlignRight, `<p style="text-align:right"><span class="math display">\[x\]</span></p>`},
+		{"Right", `<p style="text-align:right"><span class="math display">\[x\]</span></p>`},
+		{`left" onclick="x`, `<p><span class="math display">\[x\]</span></p>`},
+	} {
+		runMathJaxTests(t, []mathJaxTestCase{
+			{
+				d:   string(tc.alignment),
+				in:  "$$x$$\n\n$y$",
+				out: tc.out + "\n" + `<p><span class="math inline">\(y\)</span></p>`,
+			},
+		}, NewMathJax(WithBlockAlignment(tc.alignment)))
+	}
+}
+
+func TestSourceElement(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "same-line block",
+			in:  `$$x+y$$`,
+			out: `<p><span class="math display">\[x+y\]<span class="math-source" hidden>x+y</span></span></p>`,
+		},
+		{
+			d:  "multi-line block is escaped",
+			in: "$$\na<b \\\\\nc>d & e\n$$",
+			out: `<p><span class="math display">\[a<b \\
+c>d & e
+\]<span class="math-source" hidden>a&lt;b \\
+c&gt;d &amp; e
+</span></span></p>`,
+		},
+		{
+			d:   "inline is unchanged",
+			in:  `$x$`,
+			out: `<p><span class="math inline">\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithSourceElement(true)))
+}
+
+func TestWrap(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  `a $x$ b`,
+			out: `<p>a <tex-math><span class="math inline">\(x\)</span></tex-math> b</p>`,
+		},
+		{
+			d:  "display",
+			in: "$$x$$\ntext",
+			out: `<tex-math><p><span class="math display">\[x\]</span></p></tex-math>
+<p>text</p>`,
+		},
+	}, NewMathJax(WithWrap("<tex-math>", "</tex-math>")))
+
+	tmpl := template.Must(template.New("math").Parse(`{{.TeX}}`))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "composes with templates",
+			in:  `$x$`,
+			out: `<p>[x]</p>`,
+		},
+	}, NewMathJax(WithWrap("[", "]"), WithTemplate(tmpl)))
+}
+
+func TestShellVarGuard(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "shell variables open math by default",
+			in:  `cd $HOME/bin and $PATH`,
+			out: `<p>cd <span class="math inline">\(HOME/bin and \)</span>PATH</p>`,
+		},
+	}, MathJax)
+
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "variable followed by slash",
+			in:  `$HOME/bin`,
+			out: `<p>$HOME/bin</p>`,
+		},
+		{
+			d:   "variables followed by slash and end of line",
+			in:  `cd $HOME/bin and $PATH`,
+			out: `<p>cd $HOME/bin and $PATH</p>`,
+		},
+		{
+			d:   "variable at the end of a line",
+			in:  "set $PATH\nnow",
+			out: "<p>set $PATH\nnow</p>",
+		},
+		{
+			d:   "uppercase math followed by space",
+			in:  `$AB = CD$ and $X_1 + Y$`,
+			out: `<p><span class="math inline">\(AB = CD\)</span> and <span class="math inline">\(X_1 + Y\)</span></p>`,
+		},
+		{
+			d:   "math and a variable on one line",
+			in:  `$AB = CD$ and $HOME/bin`,
+			out: `<p><span class="math inline">\(AB = CD\)</span> and $HOME/bin</p>`,
+		},
+		{
+			d:   "variable inside a path",
+			in:  `/path/$VAR/to and $x$`,
+			out: `<p>/path/$VAR/to and <span class="math inline">\(x\)</span></p>`,
+		},
+		{
+			d:   "variable followed by colon",
+			in:  `PATH=$GOPATH:$PATH`,
+			out: `<p>PATH=$GOPATH:$PATH</p>`,
+		},
+		{
+			d:   "math still renders",
+			in:  `$x$ and $AB$ and $N^2$`,
+			out: `<p><span class="math inline">\(x\)</span> and <span class="math inline">\(AB\)</span> and <span class="math inline">\(N^2\)</span></p>`,
+		},
+	}, NewMathJax(WithShellVarGuard(true)))
+}
+
+func TestBlockquoteClass(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "inline in blockquote",
+			in: "> a $x$ b",
+			out: `<blockquote>
+<p>a <span class="math inline math-quoted">\(x\)</span> b</p>
+</blockquote>`,
+		},
+		{
+			d:  "display in blockquote",
+			in: "> $$\n> x\n> $$",
+			out: `<blockquote>
+<p><span class="math display math-quoted">\[x
+\]</span></p>
+</blockquote>`,
+		},
+		{
+			d:  "nested in a list inside a blockquote",
+			in: "> - $x$",
+			out: `<blockquote>
+<ul>
+<li><span class="math inline math-quoted">\(x\)</span></li>
+</ul>
+</blockquote>`,
+		},
+		{
+			d:  "outside blockquote",
+			in: "$x$\n\n$$y$$",
+			out: `<p><span class="math inline">\(x\)</span></p>
+<p><span class="math display">\[y\]</span></p>`,
+		},
+	}, NewMathJax(WithBlockquoteClass(true)))
+}
+
+func TestNodeKinds(t *testing.T) {
+	assert.Equal(t, "MathJaxBlock", KindMathBlock.String())
+	assert.Equal(t, "MathJaxInline", KindInlineMath.String())
+	assert.Equal(t, "MathJaxTeXLiteralBlock", KindTeXLiteralBlock.String())
+}
+
+func TestCanonicalOutput(t *testing.T) {
+	canonical := NewMathJax(
+		WithCanonicalOutput(true),
+		WithInlineDelim("$", "$"),
+		WithBlockAlignment("left"),
+		WithNoScriptFallback(true),
+		WithSourceElement(true),
+	)
+	inline := `<p><span class="math inline">\(a + b\)</span></p>`
+	display := `<p><span class="math display">\[a + b\]</span></p>`
+	runMathJaxTests(t, []mathJaxTestCase{
+		{d: "inline", in: `$a + b$`, out: inline},
+		{d: "inline with extra spaces", in: `$  a   +  b $`, out: inline},
+		{d: "inline across lines", in: "$a +\nb$", out: inline},
+		{d: "display", in: `$$a + b$$`, out: display},
+		{d: "display with extra spaces", in: `$$ a  +   b  $$`, out: display},
+		{d: "display multi-line", in: "$$\n  a +\n\tb\n$$", out: display},
+		{d: "display with a comment", in: "$$\na + % sum\nb\n$$", out: display},
+	}, canonical)
+}
+
+func TestMathJax3Containers(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  `a $x<y$ b`,
+			out: `<p>a <mjx-container class="MathJax" jax="SVG"><script type="math/tex">x<y</script></mjx-container> b</p>`,
+		},
+		{
+			d:   "display",
+			in:  `$$\frac{a}{b}$$`,
+			out: `<mjx-container class="MathJax" jax="SVG" display="true"><script type="math/tex; mode=display">\frac{a}{b}</script></mjx-container>`,
+		},
+		{
+			d:   "closing script tag is escaped",
+			in:  `$\text{</script>}$`,
+			out: `<p><mjx-container class="MathJax" jax="SVG"><script type="math/tex">\text{<\/script>}</script></mjx-container></p>`,
+		},
+	}, NewMathJax(WithMathJax3Containers(true)))
+}
+
+func TestMetaToggleKey(t *testing.T) {
+	metaKey := parser.NewContextKey()
+	getMeta := func(pc parser.Context) map[string]interface{} {
+		m, _ := pc.Get(metaKey).(map[string]interface{})
+		return m
+	}
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(WithMetaToggleKey("math", getMeta))))
+	src := []byte("$x$\n\n$$y$$")
+	enabled := `<p><span class="math inline">\(x\)</span></p>
+<p><span class="math display">\[y\]</span></p>`
+	disabled := `<p>$x$</p>
+<p>$$y$$</p>`
+
+	for _, tc := range []struct {
+		d    string
+		meta map[string]interface{}
+		out  string
+	}{
+		{"no metadata", nil, enabled},
+		{"key missing", map[string]interface{}{"title": "t"}, enabled},
+		{"key true", map[string]interface{}{"math": true}, enabled},
+		{"key false", map[string]interface{}{"math": false}, disabled},
+		{"key string false", map[string]interface{}{"math": "false"}, disabled},
+	} {
+		t.Run(tc.d, func(t *testing.T) {
+			pc := parser.NewContext()
+			if tc.meta != nil {
+				pc.Set(metaKey, tc.meta)
+			}
+			var buf bytes.Buffer
+			if err := md.Convert(src, &buf, parser.WithContext(pc)); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.out, strings.TrimSpace(buf.String()))
+		})
+	}
+}
+
+func TestDisplayMathInListItems(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "same-line",
+			in: "- $$x$$\n- b",
+			out: `<ul>
+<li>
+<p><span class="math display">\[x\]</span></p>
+</li>
+<li>b</li>
+</ul>`,
+		},
+		{
+			d:  "multi-line",
+			in: "- $$\n  x\n  $$",
+			out: `<ul>
+<li>
+<p><span class="math display">\[x
+\]</span></p>
+</li>
+</ul>`,
+		},
+		{
+			d:  "multi-line in ordered list",
+			in: "10. $$\n    x\n    $$",
+			out: `<ol start="10">
+<li>
+<p><span class="math display">\[x
+\]</span></p>
+</li>
+</ol>`,
+		},
+		{
+			d:  "nested list",
+			in: "- a\n  - $$x$$\n  - $$\n    y\n    $$",
+			out: `<ul>
+<li>a
+<ul>
+<li>
+<p><span class="math display">\[x\]</span></p>
+</li>
+<li>
+<p><span class="math display">\[y
+\]</span></p>
+</li>
+</ul>
+</li>
+</ul>`,
+		},
+		{
+			d:  "same-line followed by multi-line",
+			in: "- $$x$$\n- $$\n  y\n  $$",
+			out: `<ul>
+<li>
+<p><span class="math display">\[x\]</span></p>
+</li>
+<li>
+<p><span class="math display">\[y
+\]</span></p>
+</li>
+</ul>`,
+		},
+		{
+			d:  "same-line followed by multi-line outside a list",
+			in: "$$x$$\n$$\ny\n$$",
+			out: `<p><span class="math display">\[x\]</span></p>
+<p><span class="math display">\[y
+\]</span></p>`,
+		},
+	}, MathJax)
+}
+
+func TestMergeAdjacentDisplay(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "two blocks",
+			in: "$$a = b$$\n$$c = d$$",
+			out: `<p><span class="math display">\[\begin{aligned}
+a = b
+\\
+c = d
+\end{aligned}\]</span></p>`,
+		},
+		{
+			d:  "three blocks",
+			in: "$$a$$\n$$\nb\n$$\n$$c$$",
+			out: `<p><span class="math display">\[\begin{aligned}
+a
+\\
+b
+\\
+c
+\end{aligned}\]</span></p>`,
+		},
+		{
+			d:  "separated by a blank line",
+			in: "$$a$$\n\n$$b$$",
+			out: `<p><span class="math display">\[a\]</span></p>
+<p><span class="math display">\[b\]</span></p>`,
+		},
+		{
+			d:  "two runs",
+			in: "$$a$$\n$$b$$\n\n$$c$$\n$$d$$",
+			out: `<p><span class="math display">\[\begin{aligned}
+a
+\\
+b
+\end{aligned}\]</span></p>
+<p><span class="math display">\[\begin{aligned}
+c
+\\
+d
+\end{aligned}\]</span></p>`,
+		},
+		{
+			d:  "comment ending a row",
+			in: "$$\na % note\n$$\n$$b$$",
+			out: `<p><span class="math display">\[\begin{aligned}
+a % note
+\\
+b
+\end{aligned}\]</span></p>`,
+		},
+	}, NewMathJax(WithMergeAdjacentDisplay(true)))
+}
+
+func TestStripTeXComments(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "comment lines",
+			in: "$$\n% the identity\na = b\n  % indented\nc = d\n$$",
+			out: `<p><span class="math display">\[a = b
+c = d
+\]</span></p>`,
+		},
+		{
+			d:  "trailing comment",
+			in: "$$\na = b % why\nc\n$$",
+			out: `<p><span class="math display">\[a = b
+c
+\]</span></p>`,
+		},
+		{
+			d:   "same-line block",
+			in:  "$$a % b$$",
+			out: `<p><span class="math display">\[a\]</span></p>`,
+		},
+		{
+			d:  "escaped percent",
+			in: "$$\n50\\% = \\frac12 % half\n$$",
+			out: `<p><span class="math display">\[50\% = \frac12
+\]</span></p>`,
+		},
+		{
+			d:  "percent in text",
+			in: "$$\n\\text{50% off} % sale\n$$",
+			out: `<p><span class="math display">\[\text{50% off}
+\]</span></p>`,
+		},
+	}, NewMathJax(WithStripTeXComments(true)))
+}
+
+func TestDisplayAttribute(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "$x$",
+			out: `<p><span class="math inline" data-display="false">\(x\)</span></p>`,
+		},
+		{
+			d:   "display",
+			in:  "$$x$$",
+			out: `<p><span class="math display" data-display="true">\[x\]</span></p>`,
+		},
+	}, NewMathJax(WithDisplayAttribute(true)))
+}
+
+func TestMathMLFallback(t *testing.T) {
+	conv := MathMLConverterFunc(func(tex []byte, display bool) ([]byte, error) {
+		if string(tex) == "bad" {
+			return nil, errors.New("unsupported")
+		}
+		if display {
+			return []byte(`<math display="block"><mi>x</mi></math>`), nil
+		}
+		return []byte(`<math><mi>x</mi></math>`), nil
+	})
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "$x$",
+			out: `<p><span class="math inline"><math><mi>x</mi></math>\(x\)</span></p>`,
+		},
+		{
+			d:   "display",
+			in:  "$$x$$",
+			out: `<p><span class="math display"><math display="block"><mi>x</mi></math>\[x\]</span></p>`,
+		},
+		{
+			d:  "converter error",
+			in: "$bad$\n\n$$bad$$",
+			out: `<p><span class="math inline">\(bad\)</span></p>
+<p><span class="math display">\[bad\]</span></p>`,
+		},
+	}, NewMathJax(WithMathMLFallback(conv)))
+}
+
+func TestMathAtLineStart(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline math as a whole line",
+			in:  "$x+y$",
+			out: `<p><span class="math inline">\(x+y\)</span></p>`,
+		},
+		{
+			d:  "inline math as a whole line inside a paragraph",
+			in: "a\n$x+y$\nb",
+			out: `<p>a
+<span class="math inline">\(x+y\)</span>
+b</p>`,
+		},
+		{
+			d:   "display math as a whole line",
+			in:  "$$x+y$$",
+			out: `<p><span class="math display">\[x+y\]</span></p>`,
+		},
+		{
+			d:  "display math as a whole line after a paragraph",
+			in: "a\n$$x+y$$\nb",
+			out: `<p>a</p>
+<p><span class="math display">\[x+y\]</span></p>
+<p>b</p>`,
+		},
+	}, MathJax)
+}
+
+func TestOnMath(t *testing.T) {
+	type call struct {
+		tex     string
+		display bool
+		line    int
+	}
+	var calls []call
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(
+		WithStripTeXComments(true),
+		WithOnMath(func(tex []byte, display bool, line int) {
+			calls = append(calls, call{string(tex), display, line})
+		}),
+	)))
+	src := "Text $a$ and $b$.\n\n$$\nc % comment\n$$\n\n- $d$\n"
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(src), &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []call{
+		{"a", false, 1},
+		{"b", false, 1},
+		{"c\n", true, 3},
+		{"d", false, 7},
+	}, calls)
+
+	var written []string
+	md = goldmark.New(goldmark.WithExtensions(NewMathJax(
+		WithCanonicalOutput(true),
+		WithOnMath(func(tex []byte, display bool, line int) {
+			written = append(written, string(tex))
+		}),
+	)))
+	buf.Reset()
+	if err := md.Convert([]byte("$ a  +\nb $\n\n$$\n  c  % comment\n  d\n$$\n"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"a + b", "c d"}, written)
+}
+
+func TestInlineMathHardLineBreak(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "hard line break",
+			in:  "$a +  \nb$",
+			out: `<p><span class="math inline">\(a + b\)</span></p>`,
+		},
+		{
+			d:   "several trailing spaces and tabs",
+			in:  "$a \t   \nb$",
+			out: `<p><span class="math inline">\(a b\)</span></p>`,
+		},
+		{
+			d:  "hard line break after the math",
+			in: "$a$  \nb",
+			out: `<p><span class="math inline">\(a\)</span><br>
+b</p>`,
+		},
+	}, MathJax)
+}
+
+func TestDollarOutput(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "$x$",
+			out: `<p><span class="math inline">$x$</span></p>`,
+		},
+		{
+			d:   "inline with double dollars",
+			in:  "a $$x$$ b",
+			out: `<p>a <span class="math inline">$x$</span> b</p>`,
+		},
+		{
+			d:  "display",
+			in: "$$\nx\n$$",
+			out: `<p><span class="math display">$$x
+$$</span></p>`,
+		},
+	}, NewMathJax(WithDollarOutput(true)))
+
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "disabled",
+			in:  "$x$",
+			out: `<p><span class="math inline">\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithDollarOutput(true), WithDollarOutput(false)))
+}
+
+func TestFlankingRules(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "flanked",
+			in:  "$x$",
+			out: `<p><span class="math inline">\(x\)</span></p>`,
+		},
+		{
+			d:   "space after opener",
+			in:  "$ x$",
+			out: `<p>$ x$</p>`,
+		},
+		{
+			d:   "space before closer",
+			in:  "$x $",
+			out: `<p>$x $</p>`,
+		},
+		{
+			d:   "currency",
+			in:  "$5 and $10",
+			out: `<p>$5 and $10</p>`,
+		},
+		{
+			d:   "unflanked closer is skipped",
+			in:  "$a $b$",
+			out: `<p><span class="math inline">\(a $b\)</span></p>`,
+		},
+		{
+			d:   "closer at the start of a line",
+			in:  "$a\n$ b",
+			out: "<p>$a\n$ b</p>",
+		},
+		{
+			d:   "display math is unaffected",
+			in:  "$$ x $$",
+			out: `<p><span class="math display">\[ x \]</span></p>`,
+		},
+	}, NewMathJax(WithFlankingRules(true)))
+}
+
+func TestNewExtension(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "options are applied",
+			in:  "$x$",
+			out: `<p><span class="math inline" data-display="false">$x$</span></p>`,
+		},
+	}, NewExtension(WithDollarOutput(true), WithDisplayAttribute(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "no options",
+			in:  "$x$",
+			out: `<p><span class="math inline">\(x\)</span></p>`,
+		},
+	}, NewExtension())
+}
+
+func TestInlineLaTeXBrackets(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "standalone",
+			in:  `\[x^2\]`,
+			out: `<p><span class="math display">\[x^2\]</span></p>`,
+		},
+		{
+			d:   "mid-sentence",
+			in:  `so \[a = b\] holds`,
+			out: `<p>so <span class="math display">\[a = b\]</span> holds</p>`,
+		},
+		{
+			d:   "spanning lines",
+			in:  "so \\[a =\nb\\] holds",
+			out: `<p>so <span class="math display">\[a = b\]</span> holds</p>`,
+		},
+		{
+			d:   "escaped backslash inside",
+			in:  `\[a \\ b\]`,
+			out: `<p><span class="math display">\[a \\ b\]</span></p>`,
+		},
+		{
+			d:   "unclosed",
+			in:  `\[x`,
+			out: `<p>[x</p>`,
+		},
+		{
+			d:   "dollar math still works",
+			in:  `$x$ \[y\]`,
+			out: `<p><span class="math inline">\(x\)</span> <span class="math display">\[y\]</span></p>`,
+		},
+	}, NewMathJax(WithInlineLaTeXBrackets(true)))
+
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "disabled",
+			in:  `\[x\]`,
+			out: `<p>[x]</p>`,
+		},
+	}, MathJax)
+}
+
+func TestPreserveBlockIndent(t *testing.T) {
+	src := "  $$\n  \\begin{array}{cc}\n    a & b \\\\\n  c & d\n  \\end{array}\n  $$"
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "dedented by default",
+			in: src,
+			out: `<p><span class="math display">\[\begin{array}{cc}
+  a & b \\
+c & d
+\end{array}
+\]</span></p>`,
+		},
+	}, MathJax)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "preserved",
+			in: src,
+			out: `<p><span class="math display">\[  \begin{array}{cc}
+    a & b \\
+  c & d
+  \end{array}
+\]</span></p>`,
+		},
+		{
+			d:  "in a list item",
+			in: "- $$\n     a\n  b\n  $$",
+			out: `<ul>
+<li>
+<p><span class="math display">\[   a
+b
+\]</span></p>
+</li>
+</ul>`,
+		},
+	}, NewMathJax(WithPreserveBlockIndent(true)))
+}
+
+func TestNoTranslate(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "$x$",
+			out: `<p><span class="math inline" translate="no">\(x\)</span></p>`,
+		},
+		{
+			d:   "display",
+			in:  "$$x$$",
+			out: `<p><span class="math display" translate="no">\[x\]</span></p>`,
+		},
+	}, NewMathJax(WithNoTranslate(true)))
+}
+
+func TestUnterminatedInlineMath(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "whole document",
+			in:  "$x + y",
+			out: `<p>$x + y</p>`,
+		},
+		{
+			d:   "end of paragraph",
+			in:  "a $x\nb\n\nc",
+			out: "<p>a $x\nb</p>\n<p>c</p>",
+		},
+	}, MathJax)
+}
+
+func TestStrict(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(WithStrict(true))))
+	for _, tc := range []struct {
+		in  string
+		err string
+	}{
+		{"$x$\n\n$$y$$", ""},
+		{"$x + y", "mathjax: 1:1: unterminated inline math"},
+		{"a\n\n$$\nx", "mathjax: 3:1: unterminated display math"},
+	} {
+		var buf bytes.Buffer
+		err := md.Convert([]byte(tc.in), &buf)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.in)
+			continue
+		}
+		if assert.Error(t, err, tc.in) {
+			assert.Equal(t, tc.err, err.Error())
+		}
+		assert.Empty(t, buf.String(), tc.in)
+	}
+}
+
+func TestStrictParseError(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(WithStrict(true))))
+	for _, tc := range []struct {
+		in  string
+		err *ParseError
+	}{
+		{"text\nmore $x + y", &ParseError{Line: 2, Column: 6, Kind: "inline", Message: "unterminated inline math"}},
+		{"a\n\n  $$\nx", &ParseError{Line: 3, Column: 3, Kind: "block", Message: "unterminated display math"}},
+		{"> $$\n> x", &ParseError{Line: 1, Column: 3, Kind: "block", Message: "unterminated display math"}},
+		{"$$\nx\n\n$y", &ParseError{Line: 1, Column: 1, Kind: "block", Message: "unterminated display math"}},
+	} {
+		err := md.Convert([]byte(tc.in), &bytes.Buffer{})
+		var pe *ParseError
+		if assert.True(t, errors.As(err, &pe), tc.in) {
+			assert.Equal(t, tc.err, pe, tc.in)
+		}
+	}
+}
+
+func TestEmptyRender(t *testing.T) {
+	src := "$$$$\n\na $ $ b"
+	for _, tc := range []struct {
+		mode EmptyRenderMode
+		out  string
+	}{
+		{EmptyDelimiters, `<p><span class="math display">\[\]</span></p>
+<p>a <span class="math inline">\( \)</span> b</p>`},
+		{EmptyComment, `<!-- empty math -->
+<p>a <!-- empty math --> b</p>`},
+		{EmptyOmit, `<p>a  b</p>`},
+		{"Omit", `<p>a  b</p>`},
+		{"none", `<p><span class="math display">\[\]</span></p>
+<p>a <span class="math inline">\( \)</span> b</p>`},
+	} {
+		runMathJaxTests(t, []mathJaxTestCase{
+			{d: string(tc.mode), in: src, out: tc.out},
+		}, NewMathJax(WithEmptyRender(tc.mode)))
+	}
+}
+
+func TestMathMLDisplayAttr(t *testing.T) {
+	conv := MathMLConverterFunc(func(tex []byte, display bool) ([]byte, error) {
+		if string(tex) == "d" {
+			return []byte(`<math display="inline" xmlns="http://www.w3.org/1998/Math/MathML"><mi>d</mi></math>`), nil
+		}
+		return []byte(`<math><mi>` + string(tex) + `</mi></math>`), nil
+	})
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "$x$",
+			out: `<p><span class="math inline"><math display="inline"><mi>x</mi></math>\(x\)</span></p>`,
+		},
+		{
+			d:   "display",
+			in:  "$$x$$",
+			out: `<p><span class="math display"><math display="block"><mi>x</mi></math>\[x\]</span></p>`,
+		},
+		{
+			d:   "existing attribute is replaced",
+			in:  "$$d$$",
+			out: `<p><span class="math display"><math display="block" xmlns="http://www.w3.org/1998/Math/MathML"><mi>d</mi></math>\[d\]</span></p>`,
+		},
+	}, NewMathJax(WithMathMLFallback(conv), WithMathMLDisplayAttr(true)))
+}
+
+func TestDebugAttributes(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "a $x+1$ b",
+			out: `<p>a <span class="math inline" data-kind="inline" data-line="1" data-delim="$" data-rawlen="3">\(x+1\)</span> b</p>`,
+		},
+		{
+			d:   "inline with double dollars",
+			in:  "a\n\nb $$x$$",
+			out: "<p>a</p>\n" + `<p>b <span class="math inline" data-kind="inline" data-line="3" data-delim="$$" data-rawlen="1">\(x\)</span></p>`,
+		},
+		{
+			d:  "display",
+			in: "text\n\n$$$\nx\n$$$",
+			out: "<p>text</p>\n" + `<p><span class="math display" data-kind="display" data-line="3" data-delim="$$$" data-rawlen="2">\[x
+\]</span></p>`,
+		},
+	}, NewMathJax(WithDebugAttributes(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "length before transforms",
+			in:  "$a\u200b\\href{u}{x}$",
+			out: `<p><span class="math inline" data-kind="inline" data-line="1" data-delim="$" data-rawlen="15">\(a\)</span></p>`,
+		},
+		{
+			d:  "display length before transforms",
+			in: "$$\nb % note\n$$",
+			out: `<p><span class="math display" data-kind="display" data-line="1" data-delim="$$" data-rawlen="9">\[\displaystyle b
+\]</span></p>`,
+		},
+	}, NewMathJax(WithDebugAttributes(true), WithStripZeroWidth(true), WithStripTeXComments(true),
+		WithCommandDenylist([]string{"href"}), WithForceDisplayStyle(true)))
+}
+
+func TestBlockOpeningLineBackslash(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "trailing backslash",
+			in: "$$\\\nx\n$$",
+			out: `<p><span class="math display">\[\
+x
+\]</span></p>`,
+		},
+		{
+			d:  "trailing double backslash",
+			in: "$$a \\\\\nb\n$$",
+			out: `<p><span class="math display">\[a \\
+b
+\]</span></p>`,
+		},
+	}, MathJax)
+}
+
+func TestCodeWrapper(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "a $x < y$ b",
+			out: `<p>a <code class="math inline">\(x &lt; y\)</code> b</p>`,
+		},
+		{
+			d:  "display",
+			in: "$$\na & b\n$$",
+			out: `<p><code class="math display">\[a &amp; b
+\]</code></p>`,
+		},
+	}, NewMathJax(WithCodeWrapper(true)))
+}
+
+func TestMathInRawHTML(t *testing.T) {
+	src := []byte("<div>\n$x$ and <code>$y$</code>\n</div>\n\n<!--\n$z$\n-->\n\n$w$")
+	omitted := strings.Repeat("<!-- raw HTML omitted -->\n", 3) + `<p><span class="math inline">\(w\)</span></p>`
+	for _, tc := range []struct {
+		d      string
+		unsafe bool
+		ext    goldmark.Extender
+		out    string
+	}{
+		{"default", true, MathJax, "<div>\n$x$ and <code>$y$</code>\n</div>\n<!--\n$z$\n-->\n" +
+			`<p><span class="math inline">\(w\)</span></p>`},
+		{"enabled", true, NewMathJax(WithMathInRawHTML(true)), "<div>\n" +
+			`<span class="math inline">\(x\)</span> and <code>$y$</code>` + "\n</div>\n<!--\n$z$\n-->\n" +
+			`<p><span class="math inline">\(w\)</span></p>`},
+		{"default without unsafe", false, MathJax, omitted},
+		{"enabled without unsafe", false, NewMathJax(WithMathInRawHTML(true)), omitted},
+	} {
+		t.Run(tc.d, func(t *testing.T) {
+			var opts []renderer.Option
+			if tc.unsafe {
+				opts = append(opts, html.WithUnsafe())
+			}
+			md := goldmark.New(goldmark.WithExtensions(tc.ext), goldmark.WithRendererOptions(opts...))
+			var buf bytes.Buffer
+			if err := md.Convert(src, &buf); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.out, strings.TrimSpace(buf.String()))
+		})
+	}
+}
+
+func TestExactFenceLength(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "longer closing fence by default",
+			in:  "$$x$$$",
+			out: `<p><span class="math display">\[x\]</span></p>`,
+		},
+		{
+			d:  "longer closing line by default",
+			in: "$$\nx\n$$$\ny",
+			out: `<p><span class="math display">\[x
+\]</span></p>
+<p>y</p>`,
+		},
+	}, MathJax)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "longer closing fence is content",
+			in:  "$$x$$$ y$$",
+			out: `<p><span class="math display">\[x$$$ y\]</span></p>`,
+		},
+		{
+			d:  "longer closing line is content",
+			in: "$$\nx\n$$$\ny\n$$",
+			out: `<p><span class="math display">\[x
+$$$
+y
+\]</span></p>`,
+		},
+		{
+			d:  "matching fence closes",
+			in: "$$$\nx\n$$\n$$$",
+			out: `<p><span class="math display">\[x
+$$
+\]</span></p>`,
+		},
+	}, NewMathJax(WithExactFenceLength(true)))
+}
+
+func TestForceDisplayStyle(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "display",
+			in:  "$$\\sum_i x_i$$",
+			out: `<p><span class="math display">\[\displaystyle \sum_i x_i\]</span></p>`,
+		},
+		{
+			d:  "multi-line display",
+			in: "$$\nx\n$$",
+			out: `<p><span class="math display">\[\displaystyle x
+\]</span></p>`,
+		},
+		{
+			d:   "empty display",
+			in:  "$$$$",
+			out: `<p><span class="math display">\[\]</span></p>`,
+		},
+		{
+			d:   "inline",
+			in:  "$\\sum_i x_i$",
+			out: `<p><span class="math inline">\(\sum_i x_i\)</span></p>`,
+		},
+	}, NewMathJax(WithForceDisplayStyle(true)))
+}
+
+func TestMaxMathNodes(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline over the limit",
+			in:  "$a$ $b$ $c$ $d$",
+			out: `<p><span class="math inline">\(a\)</span> <span class="math inline">\(b\)</span> $c$ $d$</p>`,
+		},
+		{
+			d:  "display over the limit",
+			in: "$$a$$\n\n$$b$$\n\n$$\nc\n$$",
+			out: `<p><span class="math display">\[a\]</span></p>
+<p><span class="math display">\[b\]</span></p>
+<p>$$
+c
+$$</p>`,
+		},
+		{
+			d:  "display takes the limit first",
+			in: "$x$\n\n$$a$$\n\n$$b$$",
+			out: `<p>$x$</p>
+<p><span class="math display">\[a\]</span></p>
+<p><span class="math display">\[b\]</span></p>`,
+		},
+	}, NewMathJax(WithMaxMathNodes(2)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "bracket display over the limit",
+			in:  "$a$ \\[b\\] \\(c\\)",
+			out: `<p><span class="math inline">\(a\)</span> [b] (c)</p>`,
+		},
+	}, NewMathJax(WithMaxMathNodes(1), WithInlineLaTeXBrackets(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "math fence over the limit",
+			in: "$a$\n\n```math\nb\n```",
+			out: `<p><span class="math inline">\(a\)</span></p>
+<pre><code class="language-math">b
+</code></pre>`,
+		},
+	}, NewMathJax(WithMaxMathNodes(1), WithMathFence("math")))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "abandoned block takes no slot",
+			in: "$$\nx\n\n$a$ $b$",
+			out: `<p>$$
+x</p>
+<p><span class="math inline">\(a\)</span> <span class="math inline">\(b\)</span></p>`,
+		},
+	}, NewMathJax(WithMaxMathNodes(2), WithBlockBlankLineAbandon(true)))
+}
+
+func TestCloseBeforeDigit(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "allowed by default",
+			in:  "$x$5",
+			out: `<p><span class="math inline">\(x\)</span>5</p>`,
+		},
+	}, MathJax)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "allowed",
+			in:  "$x$5",
+			out: `<p><span class="math inline">\(x\)</span>5</p>`,
+		},
+	}, NewMathJax(WithCloseBeforeDigit(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "before a digit",
+			in:  "$x$5",
+			out: `<p>$x$5</p>`,
+		},
+		{
+			d:   "amounts",
+			in:  "pay $5 or $10 now",
+			out: `<p>pay $5 or $10 now</p>`,
+		},
+		{
+			d:   "later closer",
+			in:  "$a$1 + b$",
+			out: `<p><span class="math inline">\(a$1 + b\)</span></p>`,
+		},
+		{
+			d:   "before a letter",
+			in:  "$x$y",
+			out: `<p><span class="math inline">\(x\)</span>y</p>`,
+		},
+	}, NewMathJax(WithCloseBeforeDigit(false)))
+}
+
+func TestOpenAfterDigit(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "allowed by default",
+			in:  "2$x$",
+			out: `<p>2<span class="math inline">\(x\)</span></p>`,
+		},
+	}, MathJax)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "allowed",
+			in:  "2$x$",
+			out: `<p>2<span class="math inline">\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithOpenAfterDigit(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "after a digit",
+			in:  "2$x$",
+			out: `<p>2$x$</p>`,
+		},
+		{
+			d:   "double dollars after a digit",
+			in:  "2$$x$$",
+			out: `<p>2$$x$$</p>`,
+		},
+		{
+			d:   "after a space",
+			in:  "2 $x$",
+			out: `<p>2 <span class="math inline">\(x\)</span></p>`,
+		},
+		{
+			d:   "after a letter",
+			in:  "a$x$",
+			out: `<p>a<span class="math inline">\(x\)</span></p>`,
+		},
+		{
+			d:   "closing before a digit is still allowed",
+			in:  "$a$2$b$",
+			out: `<p><span class="math inline">\(a\)</span>2$b$</p>`,
+		},
+	}, NewMathJax(WithOpenAfterDigit(false)))
+}
+
+func TestNestedFenceEscape(t *testing.T) {
+	src := "$$\na\n\\$$\nb\n$$"
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "escaped fence closes by default",
+			in: src,
+			out: `<p><span class="math display">\[a
+\\]</span></p>
+<p>b</p>
+<p><span class="math display">\[\]</span></p>`,
+		},
+	}, MathJax)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "escaped fence line",
+			in: src,
+			out: `<p><span class="math display">\[a
+\$$
+b
+\]</span></p>`,
+		},
+		{
+			d:  "escaped fence after content",
+			in: "$$\na \\$$\n$$",
+			out: `<p><span class="math display">\[a \$$
+\]</span></p>`,
+		},
+		{
+			d:  "escaped backslash before the fence",
+			in: "$$\na \\\\$$\nb",
+			out: `<p><span class="math display">\[a \\\]</span></p>
+<p>b</p>`,
+		},
+		{
+			d:   "escaped fence on the opening line",
+			in:  "$$a \\$$ b$$",
+			out: `<p><span class="math display">\[a \$$ b\]</span></p>`,
+		},
+		{
+			d:  "unescaped fence still closes",
+			in: "$$\na\n$$\nb",
+			out: `<p><span class="math display">\[a
+\]</span></p>
+<p>b</p>`,
+		},
+	}, NewMathJax(WithNestedFenceEscape(true)))
+}
+
+func TestMathInInlineRawHTML(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(MathJax), goldmark.WithRendererOptions(html.WithUnsafe()))
+	for _, tc := range []mathJaxTestCase{
+		{
+			d:   "between inline tags",
+			in:  "a <span>$x$</span> b",
+			out: `<p>a <span><span class="math inline">\(x\)</span></span> b</p>`,
+		},
+		{
+			d:   "double dollars between inline tags",
+			in:  "a <p>$$x$$</p>",
+			out: `<p>a <p><span class="math inline">\(x\)</span></p></p>`,
+		},
+		{
+			d:   "in an attribute",
+			in:  `a <span title="$x$">y</span>`,
+			out: `<p>a <span title="$x$">y</span></p>`,
+		},
+		{
+			d:   "in an HTML block",
+			in:  "<p>$$x$$</p>",
+			out: `<p>$$x$$</p>`,
+		},
+	} {
+		t.Run(tc.d, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := md.Convert([]byte(tc.in), &buf); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.out, strings.TrimSpace(buf.String()))
+		})
+	}
+}
+
+func TestMergeSeparator(t *testing.T) {
+	for _, tc := range []struct {
+		sep string
+		out string
+	}{
+		{`\quad`, `<p><span class="math display">\[\begin{aligned}
+a
+\quad
+b
+\end{aligned}\]</span></p>`},
+		{`\\[2ex]`, `<p><span class="math display">\[\begin{aligned}
+a
+\\[2ex]
+b
+\end{aligned}\]</span></p>`},
+	} {
+		runMathJaxTests(t, []mathJaxTestCase{
+			{d: tc.sep, in: "$$a$$\n$$b$$", out: tc.out},
+		}, NewMathJax(WithMergeAdjacentDisplay(true), WithMergeSeparator(tc.sep)))
+	}
+}
+
+func TestScriptNonce(t *testing.T) {
+	n := 0
+	nonce := func() string {
+		n++
+		return fmt.Sprintf("n%d", n)
+	}
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "fresh nonce per script",
+			in: "$x$\n\n$$y$$",
+			out: `<p><mjx-container class="MathJax" jax="SVG"><script type="math/tex" nonce="n1">x</script></mjx-container></p>
+<mjx-container class="MathJax" jax="SVG" display="true"><script type="math/tex; mode=display" nonce="n2">y</script></mjx-container>`,
+		},
+	}, NewMathJax(WithMathJax3Containers(true), WithScriptNonce(nonce)))
+}
+
+func TestBlockFenceIndentation(t *testing.T) {
+	var tests []mathJaxTestCase
+	for n := 0; n <= 3; n++ {
+		indent := strings.Repeat(" ", n)
+		tests = append(tests, mathJaxTestCase{
+			d:  fmt.Sprintf("%d spaces", n),
+			in: indent + "$$\n" + indent + "x\n" + indent + "$$",
+			out: `<p><span class="math display">\[x
+\]</span></p>`,
+		}, mathJaxTestCase{
+			d:   fmt.Sprintf("%d spaces same-line", n),
+			in:  indent + "$$x$$",
+			out: `<p><span class="math display">\[x\]</span></p>`,
+		})
+	}
+	tests = append(tests, mathJaxTestCase{
+		d:  "4 spaces",
+		in: "    $$\n    x\n    $$",
+		out: `<pre><code>$$
+x
+$$</code></pre>`,
+	})
+	runMathJaxTests(t, tests, MathJax)
+}
+
+func TestClosingFenceIndentation(t *testing.T) {
+	var tests []mathJaxTestCase
+	for n := 0; n <= 3; n++ {
+		indent := strings.Repeat(" ", n)
+		for m := n; m <= n+3; m++ {
+			tests = append(tests, mathJaxTestCase{
+				d:   fmt.Sprintf("fence at %d, closing fence at %d", n, m),
+				in:  indent + "$$\n" + indent + "x\n" + strings.Repeat(" ", m) + "$$",
+				out: "<p><span class=\"math display\">\\[" + indent + "x\n\\]</span></p>",
+			})
+		}
+		// the indentation before a closing fence further in is content
+		tests = append(tests, mathJaxTestCase{
+			d:   fmt.Sprintf("fence at %d, closing fence at %d", n, n+4),
+			in:  indent + "$$\n" + indent + "x\n" + strings.Repeat(" ", n+4) + "$$",
+			out: "<p><span class=\"math display\">\\[" + indent + "x\n" + strings.Repeat(" ", n+4) + "\\]</span></p>",
+		})
+	}
+	tests = append(tests, mathJaxTestCase{
+		d:  "relative to a list item",
+		in: "- a\n\n     $$\n     x\n        $$",
+		out: `<ul>
+<li>
+<p>a</p>
+<p><span class="math display">\[   x
+\]</span></p>
+</li>
+</ul>`,
+	})
+	runMathJaxTests(t, tests, NewMathJax(WithPreserveBlockIndent(true)))
+}
+
+func TestTemplateElement(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "a $x < y$ b",
+			out: `<p>a <math-tex><template>\(x &lt; y\)</template></math-tex> b</p>`,
+		},
+		{
+			d:  "display",
+			in: "$$\na & b\n$$",
+			out: `<math-tex><template>\[a &amp; b
+\]</template></math-tex>`,
+		},
+	}, NewMathJax(WithTemplateElement("math-tex")))
+}
+
+func TestInlineMathSingleLine(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "two lines",
+			in:  "$a =\nb$",
+			out: `<p><span class="math inline">\(a = b\)</span></p>`,
+		},
+		{
+			d:   "three lines",
+			in:  "text $a\n= b\n= c$ text",
+			out: `<p>text <span class="math inline">\(a = b = c\)</span> text</p>`,
+		},
+		{
+			d:  "in a blockquote",
+			in: "> $a\n> = b$",
+			out: `<blockquote>
+<p><span class="math inline">\(a = b\)</span></p>
+</blockquote>`,
+		},
+		{
+			d:   "in a list item",
+			in:  "- $a\n  = b$",
+			out: "<ul>\n<li><span class=\"math inline\">\\(a = b\\)</span></li>\n</ul>",
+		},
+	}, MathJax)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "script element",
+			in:  "$a\n= b$",
+			out: `<p><mjx-container class="MathJax" jax="SVG"><script type="math/tex">a = b</script></mjx-container></p>`,
+		},
+	}, NewMathJax(WithMathJax3Containers(true)))
+}
+
+func TestAttributeLists(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "id",
+			in:  "$$x$$ {#eq1}",
+			out: `<p><span class="math display" id="eq1">\[x\]</span></p>`,
+		},
+		{
+			d:   "class",
+			in:  "$$x$$ {.important}",
+			out: `<p><span class="math display important">\[x\]</span></p>`,
+		},
+		{
+			d:   "id and classes",
+			in:  "$$x$$ {#eq1 .important .wide}",
+			out: `<p><span class="math display important wide" id="eq1">\[x\]</span></p>`,
+		},
+		{
+			d:  "after a closing fence on its own line",
+			in: "$$\nx\n$$ {#eq1 .important}",
+			out: `<p><span class="math display important" id="eq1">\[x
+\]</span></p>`,
+		},
+		{
+			d:   "after a closing fence ending a content line",
+			in:  "$$\nx $${#eq1}",
+			out: `<p><span class="math display" id="eq1">\[x \]</span></p>`,
+		},
+		{
+			d:   "no attribute list",
+			in:  "$$x$$",
+			out: `<p><span class="math display">\[x\]</span></p>`,
+		},
+		{
+			d:   "braces in the TeX",
+			in:  "$$\\frac{a}{b}$$",
+			out: `<p><span class="math display">\[\frac{a}{b}\]</span></p>`,
+		},
+		{
+			d:  "not an attribute list",
+			in: "$$\nx\n$$ {y}\n$$",
+			out: `<p><span class="math display">\[x
+$$ {y}
+\]</span></p>`,
+		},
+	}, NewMathJax(WithAttributeLists(true)))
+}
+
+func TestBlockInBlockquote(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "last in the blockquote",
+			in: "> text\n> $$\n> x\n> $$",
+			out: `<blockquote>
+<p>text</p>
+<p><span class="math display">\[x
+\]</span></p>
+</blockquote>`,
+		},
+		{
+			d:  "followed by a paragraph outside",
+			in: "> $$\n> x\n> $$\nafter",
+			out: `<blockquote>
+<p><span class="math display">\[x
+\]</span></p>
+</blockquote>
+<p>after</p>`,
+		},
+		{
+			d:  "in the middle",
+			in: "> a\n>\n> $$\n> x\n> $$\n>\n> b",
+			out: `<blockquote>
+<p>a</p>
+<p><span class="math display">\[x
+\]</span></p>
+<p>b</p>
+</blockquote>`,
+		},
+		{
+			d:  "without a space after the markers",
+			in: ">$$\n>x\n>$$\n\nafter",
+			out: `<blockquote>
+<p><span class="math display">\[x
+\]</span></p>
+</blockquote>
+<p>after</p>`,
+		},
+		{
+			d:  "closing fence after content",
+			in: "> $$\n> x $$\n> b",
+			out: `<blockquote>
+<p><span class="math display">\[x \]</span></p>
+<p>b</p>
+</blockquote>`,
+		},
+		{
+			d:  "nested blockquote",
+			in: "> > $$\n> > x\n> > $$\n> b",
+			out: `<blockquote>
+<blockquote>
+<p><span class="math display">\[x
+\]</span></p>
+</blockquote>
+<p>b</p>
+</blockquote>`,
+		},
+		{
+			d:  "unterminated block ends with the blockquote",
+			in: "> $$\n> x\n\nafter",
+			out: `<blockquote>
+<p><span class="math display">\[x
+\]</span></p>
+</blockquote>
+<p>after</p>`,
+		},
+		{
+			d:  "indented content",
+			in: "> $$\n>   x\n> y\n> $$\n\n$$\nz\n$$",
+			out: `<blockquote>
+<p><span class="math display">\[  x
+y
+\]</span></p>
+</blockquote>
+<p><span class="math display">\[z
+\]</span></p>`,
+		},
+	}, MathJax)
+}
+
+func TestNumberingMode(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "tag",
+			in: "$$\nx\n$$\n\n$$y$$",
+			out: `<p><span class="math display">\[x
+\tag{1}
+\]</span></p>
+<p><span class="math display">\[y
+\tag{2}\]</span></p>`,
+		},
+		{
+			d:   "inline math is not numbered",
+			in:  "$x$",
+			out: `<p><span class="math inline">\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithNumberingMode("tag")))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "dom",
+			in: "$$\nx\n$$\n\n$$y$$",
+			out: `<p><span class="math display">\[x
+\]</span><span class="eqno">(1)</span></p>
+<p><span class="math display">\[y\]</span><span class="eqno">(2)</span></p>`,
+		},
+	}, NewMathJax(WithNumberingMode("dom")))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "merged blocks have one number",
+			in:  "$$a$$\n$$b$$",
+			out: "<p><span class=\"math display\">\\[\\begin{aligned}\na\n\\\\\nb\n\\end{aligned}\n\\tag{1}\\]</span></p>",
+		},
+	}, NewMathJax(WithNumberingMode("tag"), WithMergeAdjacentDisplay(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "tag after a comment",
+			in: "$$\nx % note\n$$",
+			out: `<p><span class="math display">\[x % note
+\tag{1}
+\]</span></p>`,
+		},
+		{
+			d:   "tagged by the author",
+			in:  "$$a$$\n\n$$b \\tag{x}$$\n\n$$c \\notag$$\n\n$$d$$",
+			out: "<p><span class=\"math display\">\\[a\n\\tag{1}\\]</span></p>\n<p><span class=\"math display\">\\[b \\tag{x}\\]</span></p>\n<p><span class=\"math display\">\\[c \\notag\\]</span></p>\n<p><span class=\"math display\">\\[d\n\\tag{2}\\]</span></p>",
+		},
+	}, NewMathJax(WithNumberingMode("TAG")))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "dom mode skips tagged blocks",
+			in:  "$$b \\tag{x}$$\n\n$$d$$",
+			out: "<p><span class=\"math display\">\\[b \\tag{x}\\]</span></p>\n<p><span class=\"math display\">\\[d\\]</span><span class=\"eqno\">(1)</span></p>",
+		},
+	}, NewMathJax(WithNumberingMode(NumberingDOM)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "unknown mode",
+			in:  "$$x$$",
+			out: `<p><span class="math display">\[x\]</span></p>`,
+		},
+	}, NewMathJax(WithNumberingMode("tags")))
+}
+
+func TestCommandDenylist(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "href with its arguments",
+			in:  `$\frac{1}{2} \href{javascript:alert(1)}{x}$`,
+			out: `<p><span class="math inline">\(\frac{1}{2} \)</span></p>`,
+		},
+		{
+			d:   "nested braces and spaces between arguments",
+			in:  `$a\href {u} {\text{b}} c$`,
+			out: `<p><span class="math inline">\(a c\)</span></p>`,
+		},
+		{
+			d:   "optional argument",
+			in:  "$$\\includegraphics[width=1cm]{f.png}x$$",
+			out: `<p><span class="math display">\[x\]</span></p>`,
+		},
+		{
+			d:   "given without the backslash",
+			in:  `$\input{file}y$`,
+			out: `<p><span class="math inline">\(y\)</span></p>`,
+		},
+		{
+			d:   "longer command names and control symbols are kept",
+			in:  `$\hrefs \\ \{a\}$`,
+			out: `<p><span class="math inline">\(\hrefs \\ \{a\}\)</span></p>`,
+		},
+		{
+			d:   "command rejoined from the text around a removed command",
+			in:  `$\hr\href{}{}ef{javascript:alert(1)}{x}$`,
+			out: `<p><span class="math inline">\(\)</span></p>`,
+		},
+		{
+			d:   "groups after the arguments are kept",
+			in:  `$\href{u}{t}{x}^2$`,
+			out: `<p><span class="math inline">\({x}^2\)</span></p>`,
+		},
+		{
+			d:   "bracketed group after a command without optional arguments",
+			in:  `$a \in \href [x]$`,
+			out: `<p><span class="math inline">\(a \in  [x]\)</span></p>`,
+		},
+		{
+			d:   "command built with csname",
+			in:  `$a\csname href\endcsname{javascript:alert(1)}{x}b$`,
+			out: `<p><span class="math inline">\(ab\)</span></p>`,
+		},
+		{
+			d:   "allowed command built with csname",
+			in:  `$\csname alpha\endcsname$`,
+			out: `<p><span class="math inline">\(\csname alpha\endcsname\)</span></p>`,
+		},
+	}, NewMathJax(WithCommandDenylist([]string{`\href`, `\includegraphics`, "input"})))
+}
+
+func TestCommandDenylistHelpers(t *testing.T) {
+	e := NewMathJax(WithCommandDenylist([]string{"href"}))
+	assert.Equal(t, []Math{{TeX: "a ", Line: 1}}, e.ExtractMath([]byte(`$a \hr\href{}{}ef{u}{x}$`)))
+	assert.Equal(t, ErrEmptyMath, e.ValidateInline(`$\href{u}{x}$`))
+	assert.Equal(t, ErrEmptyMath, e.ValidateBlock(`$$\href{u}{x}$$`))
+	assert.NoError(t, ValidateInline(`$\href{u}{x}$`))
+
+	md := goldmark.New(goldmark.WithExtensions(e))
+	source := []byte(`$a\href{u}{x}$`)
+	doc := md.Parser().Parse(text.NewReader(source))
+	r := &recordingTeXRenderer{}
+	assert.NoError(t, e.PrerenderMath(doc, source, r))
+	assert.Equal(t, []string{"a"}, r.tex)
+}
+
+// recordingTeXRenderer records the TeX it is asked to render.
+type recordingTeXRenderer struct {
+	tex []string
+}
+
+func (r *recordingTeXRenderer) RenderTeX(tex []byte, display bool) ([]byte, error) {
+	r.tex = append(r.tex, string(tex))
+	return tex, nil
+}
+
+func TestCommandAllowlist(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "allowed commands are kept",
+			in:  `$\frac{1}{\alpha} \href{u}{x}$`,
+			out: `<p><span class="math inline">\(\frac{1}{\alpha} \)</span></p>`,
+		},
+		{
+			d:   "control symbols are kept",
+			in:  `$a \, b \\ c$`,
+			out: `<p><span class="math inline">\(a \, b \\ c\)</span></p>`,
+		},
+	}, NewMathJax(WithCommandAllowlist([]string{"frac", "alpha"})))
+}
+
+func TestUnclosedInlineOpeners(t *testing.T) {
+	// under the flanking rules a dollar after white space does not close
+	// inline math, so none of these openers is closed
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "several unclosed openers",
+			in:  "$a $b $c",
+			out: `<p>$a $b $c</p>`,
+		},
+		{
+			d:   "unclosed openers over several lines",
+			in:  "$a\n$b\n$c",
+			out: "<p>$a\n$b\n$c</p>",
+		},
+		{
+			d:   "a double dollar opener after an unclosed single one",
+			in:  "$a $$b$$",
+			out: `<p>$a <span class="math inline">\(b\)</span></p>`,
+		},
+		{
+			d:   "unclosed openers in separate paragraphs",
+			in:  "$a $b\n\n$c$",
+			out: "<p>$a $b</p>\n<p><span class=\"math inline\">\\(c\\)</span></p>",
+		},
+	}, NewMathJax(WithFlankingRules(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "an opener inside the text group of an unclosed one",
+			in:  `$a \text{b $c$ d}`,
+			out: `<p>$a \text{b <span class="math inline">\(c\)</span> d}</p>`,
+		},
+		{
+			d:   "openers before digits",
+			in:  "$1 $2 $3",
+			out: `<p>$1 $2 $3</p>`,
+		},
+	}, NewMathJax(WithCloseBeforeDigit(false)))
+}
+
+func BenchmarkUnclosedInlineMath(b *testing.B) {
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(WithFlankingRules(true))))
+	src := bytes.Repeat([]byte("$a "), 10000)
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := md.Convert(src, &buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManyMathParagraphs(b *testing.B) {
+	md := goldmark.New(goldmark.WithExtensions(MathJax))
+	src := bytes.Repeat([]byte("a $x$ b\n\n$$y$$\n\n"), 20000)
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := md.Convert(src, &buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTaskListMath(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "after the checkbox",
+			in: "- [ ] compute $x^2$",
+			out: `<ul>
+<li><input disabled="" type="checkbox"> compute <span class="math inline">\(x^2\)</span></li>
+</ul>`,
+		},
+		{
+			d:  "directly after the checkbox",
+			in: "- [x] $a$ and $$b$$",
+			out: `<ul>
+<li><input checked="" disabled="" type="checkbox"> <span class="math inline">\(a\)</span> and <span class="math inline">\(b\)</span></li>
+</ul>`,
+		},
+		{
+			d:  "display math in the item",
+			in: "- [ ] $x$\n  $$\n  y\n  $$",
+			out: `<ul>
+<li><input disabled="" type="checkbox"> <span class="math inline">\(x\)</span>
+<p><span class="math display">\[y
+\]</span></p>
+</li>
+</ul>`,
+		},
+	}, MathJax, extension.TaskList)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "with GFM",
+			in: "* [X] $|x|$ | a",
+			out: `<ul>
+<li><input checked="" disabled="" type="checkbox"> <span class="math inline">\(|x|\)</span> | a</li>
+</ul>`,
+		},
+	}, MathJax, extension.GFM)
+}
+
+type registerFunc func(reg renderer.NodeRendererFuncRegisterer)
+
+func (f registerFunc) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	f(reg)
+}
+
+func TestRegisterRenderers(t *testing.T) {
+	source := []byte("x^2\ny")
+	doc := ast.NewDocument()
+	p := ast.NewParagraph()
+	inline := NewInlineMath()
+	inline.AppendChild(inline, ast.NewRawTextSegment(text.NewSegment(0, 3)))
+	p.AppendChild(p, inline)
+	doc.AppendChild(doc, p)
+	block := NewMathBlock()
+	block.Lines().Append(text.NewSegment(4, 5))
+	doc.AppendChild(doc, block)
+
+	r := renderer.NewRenderer(renderer.WithNodeRenderers(
+		util.Prioritized(html.NewRenderer(), 1000),
+		util.Prioritized(registerFunc(func(reg renderer.NodeRendererFuncRegisterer) {
+			RegisterRenderers(reg, WithDisplayAttribute(true))
+		}), 500),
+	))
+	var buf bytes.Buffer
+	if err := r.Render(&buf, source, doc); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `<p><span class="math inline" data-display="false">\(x^2\)</span></p>
+<p><span class="math display" data-display="true">\[y\]</span></p>
+`, buf.String())
+}
+
+func TestAMSEnvironments(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "commutative diagram",
+			in: "\\begin{CD}\nA @>f>> B\\\\\n@VgVV @VVhV\nC @>>k> D\n\\end{CD}",
+			out: `<p><span class="math display cd">\[\begin{CD}
+A @>f>> B\\
+@VgVV @VVhV
+C @>>k> D
+\end{CD}\]</span></p>`,
+		},
+		{
+			d:  "interrupting a paragraph",
+			in: "text\n\\begin{align}\na &= b\n\\end{align}\nafter",
+			out: `<p>text</p>
+<p><span class="math display">\[\begin{align}
+a &= b
+\end{align}
+\]</span></p>
+<p>after</p>`,
+		},
+		{
+			d:   "on one line",
+			in:  "\\begin{equation} x \\end{equation}",
+			out: `<p><span class="math display">\[\begin{equation} x \end{equation}\]</span></p>`,
+		},
+		{
+			d:  "in a blockquote",
+			in: "> \\begin{CD}\n> A\n> \\end{CD}",
+			out: `<blockquote>
+<p><span class="math display cd">\[\begin{CD}
+A
+\end{CD}\]</span></p>
+</blockquote>`,
+		},
+		{
+			d:  "ended by a blank line",
+			in: "\\begin{equation}\nx\n\ny",
+			out: `<p><span class="math display">\[\begin{equation}
+x
+\]</span></p>
+<p>y</p>`,
+		},
+		{
+			d:  "other environments",
+			in: "\\begin{foo}\nx\n\\end{foo}",
+			out: `<p>\begin{foo}
+x
+\end{foo}</p>`,
+		},
+	}, NewMathJax(WithAMSEnvironments(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "disabled",
+			in: "\\begin{CD}\nA\n\\end{CD}",
+			out: `<p>\begin{CD}
+A
+\end{CD}</p>`,
+		},
+	}, MathJax)
+}
+
+func TestFinalNewline(t *testing.T) {
+	for _, tc := range []struct {
+		mode FinalNewlineMode
+		out  string
+	}{
+		{FinalNewlineKeep, "<p><span class=\"math display\">\\[a\nb\n\\]</span></p>"},
+		{FinalNewlineDrop, "<p><span class=\"math display\">\\[a\nb\\]</span></p>"},
+		{FinalNewlineSpace, "<p><span class=\"math display\">\\[a\nb \\]</span></p>"},
+		{"Drop", "<p><span class=\"math display\">\\[a\nb\\]</span></p>"},
+		{"trim", "<p><span class=\"math display\">\\[a\nb\n\\]</span></p>"},
+	} {
+		runMathJaxTests(t, []mathJaxTestCase{
+			{
+				d:   string(tc.mode),
+				in:  "$$\na\nb\n$$",
+				out: tc.out,
+			},
+			{
+				d:   string(tc.mode) + " same-line block",
+				in:  "$$a$$",
+				out: `<p><span class="math display">\[a\]</span></p>`,
+			},
+		}, NewMathJax(WithFinalNewline(tc.mode)))
+	}
+}
+
+func TestSourceRange(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(WithInlineLaTeXBrackets(true), WithAMSEnvironments(true))))
+	for _, tc := range []struct {
+		d      string
+		in     string
+		ranges []string
+	}{
+		{"inline", "a $x$ b $$y$$", []string{"$x$", "$$y$$"}},
+		{"inline over two lines", "a $x\ny$ b", []string{"$x\ny$"}},
+		{"bracket", `a \[x\] b`, []string{`\[x\]`}},
+		{"same-line block", "$$x$$\n", []string{"$$x$$"}},
+		{"multi-line block", "a\n\n$$\nx\n$$\nb", []string{"$$\nx\n$$"}},
+		{"closing fence after content", "$$x\ny $$ \n", []string{"$$x\ny $$"}},
+		{"longer fences", "$$$\nx\n$$$$\n", []string{"$$$\nx\n$$$$"}},
+		{"in a blockquote", "> $$\n> x\n> $$", []string{"$$\n> x\n> $$"}},
+		{"unterminated block", "$$\nx\ny  \n", []string{"$$\nx\ny"}},
+		{"environment", "\\begin{CD}\nA\n\\end{CD} \n", []string{"\\begin{CD}\nA\n\\end{CD}"}},
+	} {
+		source := []byte(tc.in)
+		doc := md.Parser().Parse(text.NewReader(source))
+		var ranges []string
+		_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if r, ok := n.(interface{ SourceRange() (int, int) }); ok && entering {
+				start, end := r.SourceRange()
+				ranges = append(ranges, string(source[start:end]))
+			}
+			return ast.WalkContinue, nil
+		})
+		assert.Equal(t, tc.ranges, ranges, tc.d)
+	}
+}
+
+func TestMathInEmphasis(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "bold",
+			in:  "**bold $x$**",
+			out: `<p><strong>bold <span class="math inline">\(x\)</span></strong></p>`,
+		},
+		{
+			d:   "emphasis delimiter inside italic math",
+			in:  "*a $x*y$*",
+			out: `<p><em>a <span class="math inline">\(x*y\)</span></em></p>`,
+		},
+		{
+			d:   "underscores inside italic math",
+			in:  "_$a_b_c$_",
+			out: `<p><em><span class="math inline">\(a_b_c\)</span></em></p>`,
+		},
+		{
+			d:   "emphasis delimiter inside bold math",
+			in:  "**$a**b$**",
+			out: `<p><strong><span class="math inline">\(a**b\)</span></strong></p>`,
+		},
+		{
+			d:   "underscores inside bold math",
+			in:  "__a $x__y$__",
+			out: `<p><strong>a <span class="math inline">\(x__y\)</span></strong></p>`,
+		},
+		{
+			d:   "link syntax inside math",
+			in:  "*$[a](b)$*",
+			out: `<p><em><span class="math inline">\([a](b)\)</span></em></p>`,
+		},
+	}, MathJax)
+}
+
+// fencedCodeHighlighter stands in for a syntax highlighting extension such
+// as goldmark-highlighting, which renders all fenced code blocks.
+type fencedCodeHighlighter struct{}
+
+func (fencedCodeHighlighter) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(registerFunc(func(reg renderer.NodeRendererFuncRegisterer) {
+			reg.Register(ast.KindFencedCodeBlock, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+				if entering {
+					_, _ = w.WriteString("<pre class=\"highlight\"></pre>\n")
+				}
+				return ast.WalkSkipChildren, nil
+			})
+		}), 200),
+	))
+}
+
+func TestMathFence(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "math fence",
+			in: "```math\na & b\n```",
+			out: `<p><span class="math display">\[a & b
+\]</span></p>`,
+		},
+		{
+			d:  "tilde fence in a list",
+			in: "- ~~~ math\n  x\n  ~~~",
+			out: `<ul>
+<li>
+<p><span class="math display">\[x
+\]</span></p>
+</li>
+</ul>`,
+		},
+		{
+			d:   "other fences",
+			in:  "```go\nx\n```",
+			out: `<pre class="highlight"></pre>`,
+		},
+	}, NewMathJax(WithMathFence("math")), fencedCodeHighlighter{})
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "line and delimiter",
+			in: "a\n\n~~~~ math\nx\n~~~~",
+			out: `<p>a</p>
+<p><span class="math display" data-kind="display" data-line="3" data-delim="~~~~" data-rawlen="2">\[x
+\]</span></p>`,
+		},
+	}, NewMathJax(WithMathFence("math"), WithDebugAttributes(true)))
+}
+
+func TestBlankLineInBlock(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "include",
+			in: "$$\na\n\nb\n$$",
+			out: `<p><span class="math display">\[a
+
+b
+\]</span></p>`,
+		},
+	}, NewMathJax(WithBlankLineInBlock(BlankLineInclude)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "default",
+			in: "$$\na\n\nb\n$$",
+			out: `<p><span class="math display">\[a
+
+b
+\]</span></p>`,
+		},
+	}, MathJax)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "terminate",
+			in: "$$\na\n\nb\n$$\nc",
+			out: `<p><span class="math display">\[a
+\]</span></p>
+<p>b</p>
+<p><span class="math display">\[c\]</span></p>`,
+		},
+		{
+			d:  "no blank line",
+			in: "$$\na\nb\n$$",
+			out: `<p><span class="math display">\[a
+b
+\]</span></p>`,
+		},
+	}, NewMathJax(WithBlankLineInBlock(BlankLineTerminate)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "case-insensitive",
+			in: "$$\na\n\nb\n$$\nc",
+			out: `<p><span class="math display">\[a
+\]</span></p>
+<p>b</p>
+<p><span class="math display">\[c\]</span></p>`,
+		},
+	}, NewMathJax(WithBlankLineInBlock("Terminate")))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "unknown mode",
+			in: "$$\na\n\nb\n$$",
+			out: `<p><span class="math display">\[a
+
+b
+\]</span></p>`,
+		},
+	}, NewMathJax(WithBlankLineInBlock("stop")))
+}
+
+func TestTitleSource(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "a $x+y$ b",
+			out: `<p>a <span class="math inline" title="$x+y$">\(x+y\)</span> b</p>`,
+		},
+		{
+			d:   "escaped",
+			in:  `$a<b \text{"c"}$`,
+			out: `<p><span class="math inline" title="$a&lt;b \text{&quot;c&quot;}$">\(a<b \text{"c"}\)</span></p>`,
+		},
+		{
+			d:   "same-line block",
+			in:  "$$x$$",
+			out: `<p><span class="math display" title="$$x$$">\[x\]</span></p>`,
+		},
+		{
+			d:  "multi-line block",
+			in: "$$\nx & y\n$$",
+			out: `<p><span class="math display" title="$$
+x &amp; y
+$$">\[x & y
+\]</span></p>`,
+		},
+	}, NewMathJax(WithTitleSource(true)))
+}
+
+func TestBlockBlankLineAbandon(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "unterminated block",
+			in:  "$$x\n$y$\nmore",
+			out: "<p>$$x\n<span class=\"math inline\">\\(y\\)</span>\nmore</p>",
+		},
+		{
+			d:  "no closing fence before a blank line",
+			in: "$$\nx\n\n$y$\n\n$$\nz\n$$",
+			out: `<p>$$
+x</p>
+<p><span class="math inline">\(y\)</span></p>
+<p><span class="math display">\[z
+\]</span></p>`,
+		},
+		{
+			d:  "in a blockquote",
+			in: "> $$\n> x\n>\n> $y$",
+			out: `<blockquote>
+<p>$$
+x</p>
+<p><span class="math inline">\(y\)</span></p>
+</blockquote>`,
+		},
+		{
+			d:  "closed block",
+			in: "$$\nx\ny $$\n\nmore",
+			out: `<p><span class="math display">\[x
+y \]</span></p>
+<p>more</p>`,
+		},
+	}, NewMathJax(WithBlockBlankLineAbandon(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "closing fence with an attribute list",
+			in:  "$$\nx\n$$ {#eq1 .big}",
+			out: "<p><span class=\"math display big\" id=\"eq1\">\\[x\n\\]</span></p>",
+		},
+	}, NewMathJax(WithBlockBlankLineAbandon(true), WithAttributeLists(true)))
+}
+
+func TestNumberingPerDocument(t *testing.T) {
+	// the same Markdown converts both documents
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(WithNumberingMode("dom"))))
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"$$a$$\n\n$$b$$", `<p><span class="math display">\[a\]</span><span class="eqno">(1)</span></p>
+<p><span class="math display">\[b\]</span><span class="eqno">(2)</span></p>
+`},
+		{"$$c$$", `<p><span class="math display">\[c\]</span><span class="eqno">(1)</span></p>
+`},
+	} {
+		var buf bytes.Buffer
+		if err := md.Convert([]byte(tc.in), &buf); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tc.out, buf.String())
+	}
+}
+
+func TestRefChecking(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(WithRefChecking(true))))
+	convert := func(src string) []Diagnostic {
+		pc := parser.NewContext()
+		var buf bytes.Buffer
+		if err := md.Convert([]byte(src), &buf, parser.WithContext(pc)); err != nil {
+			t.Fatal(err)
+		}
+		return Diagnostics(pc)
+	}
+
+	assert.Empty(t, convert("See $\\eqref{eq:a}$ and $\\ref{eq:b}$.\n\n$$\nx \\label{eq:a}\n$$\n\n$$y \\label{ eq:b }$$"))
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `unresolved reference "eq:c" at line 1`},
+		{Line: 3, Message: `unresolved reference "eq:d" at line 3`},
+	}, convert("See $\\eqref{eq:c}$.\n\n$$\nx = \\ref{eq:d} \\label{eq:a}\n$$"))
+
+	md = goldmark.New(goldmark.WithExtensions(MathJax))
+	assert.Empty(t, convert("$\\eqref{eq:c}$"))
+}
+
+func TestContainerFence(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "container fence",
+			in: ":::math\na & b\n:::",
+			out: `<p><span class="math display">\[a & b
+\]</span></p>`,
+		},
+		{
+			d:  "dollars, blank lines and other fences in the content",
+			in: "text\n::: math\n$$x$$\n\n:::note\ny\n:::: \nafter",
+			out: `<p>text</p>
+<p><span class="math display">\[$$x$$
+
+:::note
+y
+\]</span></p>
+<p>after</p>`,
+		},
+		{
+			d:  "longer fence around a shorter one",
+			in: "::::math\na\n:::\nb\n::::",
+			out: `<p><span class="math display">\[a
+:::
+b
+\]</span></p>`,
+		},
+		{
+			d:  "in a list",
+			in: "- :::math\n    x\n  :::",
+			out: `<ul>
+<li>
+<p><span class="math display">\[  x
+\]</span></p>
+</li>
+</ul>`,
+		},
+		{
+			d:  "other containers",
+			in: ":::note\nx\n:::",
+			out: `<p>:::note
+x
+:::</p>`,
+		},
+	}, NewMathJax(WithContainerFence("math")))
+}
+
+func TestHTMLEscapeEnabled(t *testing.T) {
+	tmpl := template.Must(template.New("math").Parse(`{{.TeX}}`))
+	for _, tc := range []struct {
+		d      string
+		ext    goldmark.Extender
+		escape bool
+	}{
+		{"default", MathJax, false},
+		{"code wrapper", NewMathJax(WithCodeWrapper(true)), true},
+		{"template element", NewMathJax(WithTemplateElement("math-tex")), true},
+		{"template over code wrapper", NewMathJax(WithCodeWrapper(true), WithTemplate(tmpl)), false},
+		{"canonical output over code wrapper", NewMathJax(WithCodeWrapper(true), WithCanonicalOutput(true)), false},
+	} {
+		md := goldmark.New(goldmark.WithExtensions(tc.ext))
+		pc := parser.NewContext()
+		var buf bytes.Buffer
+		if err := md.Convert([]byte("$x$"), &buf, parser.WithContext(pc)); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tc.escape, HTMLEscapeEnabled(pc), tc.d)
+	}
+	assert.False(t, HTMLEscapeEnabled(parser.NewContext()))
+}
+
+func TestMaxBlockLines(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "at the limit",
+			in: "$$\nx\ny\n$$",
+			out: `<p><span class="math display">\[x
+y
+\]</span></p>`,
+		},
+		{
+			d:  "closing fence after content at the limit",
+			in: "$$\nx\ny $$",
+			out: `<p><span class="math display">\[x
+y \]</span></p>`,
+		},
+		{
+			d:   "over the limit",
+			in:  "$$\nx\ny\nz\n$$",
+			out: "<p>$$\nx\ny\nz\n$$</p>",
+		},
+		{
+			d:   "unterminated",
+			in:  "$$x\n$y$\nmore",
+			out: "<p>$$x\n<span class=\"math inline\">\\(y\\)</span>\nmore</p>",
+		},
+		{
+			d:   "same-line block",
+			in:  "$$x$$",
+			out: `<p><span class="math display">\[x\]</span></p>`,
+		},
+	}, NewMathJax(WithMaxBlockLines(4)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "closing fence with an attribute list",
+			in:  "$$\nx\n$$ {#eq1}",
+			out: "<p><span class=\"math display\" id=\"eq1\">\\[x\n\\]</span></p>",
+		},
+		{
+			d:   "blockquote",
+			in:  "> $$\n> x\n> $$",
+			out: "<blockquote>\n<p><span class=\"math display\">\\[x\n\\]</span></p>\n</blockquote>",
+		},
+		{
+			d:   "fence after the end of a blockquote",
+			in:  "> $$\n> x\n\n$$",
+			out: "<blockquote>\n<p>$$\nx</p>\n</blockquote>\n<p>$$</p>",
+		},
+		{
+			d:   "list item",
+			in:  "- $$\n  x\n  $$",
+			out: "<ul>\n<li>\n<p><span class=\"math display\">\\[x\n\\]</span></p>\n</li>\n</ul>",
+		},
+	}, NewMathJax(WithMaxBlockLines(5), WithAttributeLists(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "quote marker outside a blockquote",
+			in:  "$$\nx\n>\n$$",
+			out: "<p><span class=\"math display\">\\[x\n>\n\\]</span></p>",
+		},
+	}, NewMathJax(WithBlockBlankLineAbandon(true)))
+}
+
+func TestAutoIDs(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(NewMathJax(WithAutoIDs("math-"), WithAttributeLists(true))))
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"a $x$ b\n\n$$\ny\n$$\n\n- $z$\n\n$$w$$ {#named}", `<p>a <span class="math inline" id="math-0">\(x\)</span> b</p>
+<p><span class="math display" id="math-1">\[y
+\]</span></p>
+<ul>
+<li><span class="math inline" id="math-2">\(z\)</span></li>
+</ul>
+<p><span class="math display" id="named">\[w\]</span></p>
+`},
+		{"$x$ and $y$", `<p><span class="math inline" id="math-0">\(x\)</span> and <span class="math inline" id="math-1">\(y\)</span></p>
+`},
+	} {
+		var buf bytes.Buffer
+		if err := md.Convert([]byte(tc.in), &buf); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tc.out, buf.String())
+	}
+}
+
+func TestDir(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "a $x$ b",
+			out: `<p>a <span class="math inline" dir="ltr">\(x\)</span> b</p>`,
+		},
+		{
+			d:   "display",
+			in:  "$$x$$",
+			out: `<p><span class="math display" dir="ltr">\[x\]</span></p>`,
+		},
+	}, NewMathJax(WithDir("ltr")))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "escaped",
+			in:  "$x$",
+			out: `<p><span class="math inline" dir="&quot; onmouseover=&quot;alert(1)">\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithDir(`" onmouseover="alert(1)`)))
+}
+
+func TestSameLineBlockSingleDollar(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "single dollar inside",
+			in:  "$$a$b$$",
+			out: `<p><span class="math display">\[a$b\]</span></p>`,
+		},
+		{
+			d:   "two single dollars inside",
+			in:  "$$a$b$ c$$",
+			out: `<p><span class="math display">\[a$b$ c\]</span></p>`,
+		},
+		{
+			d:   "single dollar between spaces",
+			in:  "$$ a $ $$",
+			out: `<p><span class="math display">\[ a $ \]</span></p>`,
+		},
+		{
+			d:   "longer closing fence",
+			in:  "$$a$$$",
+			out: `<p><span class="math display">\[a\]</span></p>`,
+		},
+	}, MathJax)
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "single dollar inside with exact fences",
+			in:  "$$a$b$$",
+			out: `<p><span class="math display">\[a$b\]</span></p>`,
+		},
+	}, NewMathJax(WithExactFenceLength(true)))
+}
+
+// Any number of blank lines between display blocks yields the same output,
+// so no option is needed to normalize the spacing.
+func TestBlankLinesBetweenBlocks(t *testing.T) {
+	for _, tc := range []struct {
+		d      string
+		format string
+	}{
+		{"same-line blocks", "$$a$$%s$$b$$"},
+		{"multi-line blocks", "$$\na\n$$%s$$\nb\n$$"},
+		{"in a list item", "- $$a$$%s  $$b$$"},
+	} {
+		var outs []string
+		for _, sep := range []string{"\n\n", "\n\n\n", "\n\n\n\n"} {
+			out, err := renderMarkdown([]byte(fmt.Sprintf(tc.format, sep)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			outs = append(outs, string(out))
+		}
+		assert.Equal(t, outs[0], outs[1], tc.d)
+		assert.Equal(t, outs[0], outs[2], tc.d)
+	}
+}
+
+func TestEscapedDollarRun(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "escaped fence",
+			in:  `\$$`,
+			out: `<p>$$</p>`,
+		},
+		{
+			d:   "both dollars escaped",
+			in:  `\$\$`,
+			out: `<p>$$</p>`,
+		},
+		{
+			d:   "escaped fences around a line",
+			in:  "\\$$\nx\n\\$$",
+			out: "<p>$$\nx\n$$</p>",
+		},
+		{
+			d:   "escaped same-line block",
+			in:  `\$$x$$`,
+			out: `<p>$$x$$</p>`,
+		},
+		{
+			d:   "within a line",
+			in:  `a \$$ b`,
+			out: `<p>a $$ b</p>`,
+		},
+		{
+			d:   "escaped backslash before a fence",
+			in:  `\\$$x$$`,
+			out: `<p>\<span class="math inline">\(x\)</span></p>`,
+		},
+		{
+			d:   "inline math after an escaped dollar and a space",
+			in:  `\$ $x$`,
+			out: `<p>$ <span class="math inline">\(x\)</span></p>`,
+		},
+	}, MathJax)
+}
+
+func TestAccessibleBlocks(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "display block",
+			in: "$$\na < b & \"c\"\n$$",
+			out: `<div role="math" aria-label="a &lt; b &amp; &quot;c&quot;" class="math display">\[a < b & "c"
+\]</div>`,
+		},
+		{
+			d:   "same-line block",
+			in:  "$$x$$",
+			out: `<div role="math" aria-label="x" class="math display">\[x\]</div>`,
+		},
+		{
+			d:   "inline math is unchanged",
+			in:  "$x$",
+			out: `<p><span class="math inline">\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithAccessibleBlocks(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "with DOM numbering and alignment",
+			in:  "$$x$$",
+			out: `<div role="math" aria-label="x" style="text-align:left" class="math display">\[x\]<span class="eqno">(1)</span></div>`,
+		},
+	}, NewMathJax(WithAccessibleBlocks(true), WithNumberingMode("dom"), WithBlockAlignment("left")))
+}
+
+func TestTeXAnnotation(t *testing.T) {
+	conv := MathMLConverterFunc(func(tex []byte, display bool) ([]byte, error) {
+		if display {
+			return []byte(`<math display="block"><mi>a</mi><mo>&lt;</mo><mi>b</mi></math>`), nil
+		}
+		return []byte(`<math><mi>x</mi></math>`), nil
+	})
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "inline",
+			in:  "$x$",
+			out: `<p><span class="math inline"><math><semantics><mrow><mi>x</mi></mrow><annotation encoding="application/x-tex">x</annotation></semantics></math>\(x\)</span></p>`,
+		},
+		{
+			d:   "display with escaped TeX",
+			in:  "$$a < b \\text{\"&\"}$$",
+			out: `<p><span class="math display"><math display="block"><semantics><mrow><mi>a</mi><mo>&lt;</mo><mi>b</mi></mrow><annotation encoding="application/x-tex">a &lt; b \text{&quot;&amp;&quot;}</annotation></semantics></math>\[a < b \text{"&"}\]</span></p>`,
+		},
+	}, NewMathJax(WithMathMLFallback(conv), WithTeXAnnotation(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "not MathML",
+			in:  "$x$",
+			out: `<p><span class="math inline"><svg></svg>\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithMathMLFallback(MathMLConverterFunc(func(tex []byte, display bool) ([]byte, error) {
+		return []byte(`<svg></svg>`), nil
+	})), WithTeXAnnotation(true)))
+}
+
+func TestInlineMathAtEndOfDocument(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "closed",
+			in:  "$x$",
+			out: `<p><span class="math inline">\(x\)</span></p>`,
+		},
+		{
+			d:   "closed after text",
+			in:  "a $x$",
+			out: `<p>a <span class="math inline">\(x\)</span></p>`,
+		},
+		{
+			d:   "closed on the second line",
+			in:  "text\n$x$",
+			out: "<p>text\n<span class=\"math inline\">\\(x\\)</span></p>",
+		},
+		{
+			d:   "unterminated",
+			in:  "$x",
+			out: `<p>$x</p>`,
+		},
+		{
+			d:   "unterminated after text",
+			in:  "a $x",
+			out: `<p>a $x</p>`,
+		},
+		{
+			d:   "lone dollar",
+			in:  "$",
+			out: `<p>$</p>`,
+		},
+		{
+			d:   "closer shorter than the opener",
+			in:  "a $$x$",
+			out: `<p>a $$x$</p>`,
+		},
+	}, MathJax)
+}
+
+func TestInlineDisplayStyle(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "double dollars in a paragraph",
+			in:  "a $$x$$ b",
+			out: `<p>a <span class="math inline">\(\displaystyle x\)</span> b</p>`,
+		},
+		{
+			d:   "single dollars",
+			in:  "a $x$ b",
+			out: `<p>a <span class="math inline">\(x\)</span> b</p>`,
+		},
+		{
+			d:   "display block",
+			in:  "$$x$$",
+			out: `<p><span class="math display">\[x\]</span></p>`,
+		},
+	}, NewMathJax(WithInlineDisplayStyle(true)))
+}
+
+func TestEquationNumberFunc(t *testing.T) {
+	chapter := 4
+	number := WithEquationNumberFunc(func(seq int) string {
+		return fmt.Sprintf("%d.%d", chapter, seq)
+	})
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "dom",
+			in: "$$a$$\n\n$$b$$",
+			out: `<p><span class="math display">\[a\]</span><span class="eqno">(4.1)</span></p>
+<p><span class="math display">\[b\]</span><span class="eqno">(4.2)</span></p>`,
+		},
+	}, NewMathJax(WithNumberingMode("dom"), number))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "tag",
+			in: "$$a$$\n\n$$b$$",
+			out: `<p><span class="math display">\[a
+\tag{4.1}\]</span></p>
+<p><span class="math display">\[b
+\tag{4.2}\]</span></p>`,
+		},
+	}, NewMathJax(WithNumberingMode("tag"), number))
+}
+
+func TestStripZeroWidth(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "zero width space in inline math",
+			in:  "$\\frac\u200b{1}{2}$",
+			out: `<p><span class="math inline">\(\frac{1}{2}\)</span></p>`,
+		},
+		{
+			d:   "byte order mark and joiners in block math",
+			in:  "$$\n\ufeffx\u200d + \u200cy\u2060\n$$",
+			out: "<p><span class=\"math display\">\\[x + y\n\\]</span></p>",
+		},
+		{
+			d:   "other characters are kept",
+			in:  "$\u00e9\u00a0x$",
+			out: "<p><span class=\"math inline\">\\(\u00e9\u00a0x\\)</span></p>",
+		},
+	}, NewMathJax(WithStripZeroWidth(true)))
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "disabled by default",
+			in:  "$a\u200bb$",
+			out: "<p><span class=\"math inline\">\\(a\u200bb\\)</span></p>",
+		},
+	}, NewMathJax())
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "custom set of code points",
+			in:  "$a\u200bb\u00adc$",
+			out: "<p><span class=\"math inline\">\\(a\u200bbc\\)</span></p>",
+		},
+	}, NewMathJax(WithStripZeroWidth(true), WithZeroWidthRunes([]rune{'\u00ad'})))
+}
+
+func TestDisplayMathInDeepSublists(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "eight-space indent",
+			in: "- a\n    - b\n\n        $$\n        x\n          y\n        $$",
+			out: `<ul>
+<li>a
+<ul>
+<li>
+<p>b</p>
+<p><span class="math display">\[x
+  y
+\]</span></p>
+</li>
+</ul>
+</li>
+</ul>`,
+		},
+		{
+			d:  "ordered sublist",
+			in: "1. a\n   1. b\n\n      $$\n      x\n        y\n      $$",
+			out: `<ol>
+<li>a
+<ol>
+<li>
+<p>b</p>
+<p><span class="math display">\[x
+  y
+\]</span></p>
+</li>
+</ol>
+</li>
+</ol>`,
+		},
+		{
+			d:  "tab indent",
+			in: "- a\n\t- b\n\n\t\t$$\n\t\tx\n\t\t\ty\n\t\t$$",
+			out: `<ul>
+<li>a
+<ul>
+<li>
+<p>b</p>
+<p><span class="math display">\[x
+    y
+\]</span></p>
+</li>
+</ul>
+</li>
+</ul>`,
+		},
+		{
+			d:  "tab across the content column of the sublist",
+			in: "- a\n  - b\n\n  \t$$\n  \tx\n  \t  y\n  \t$$",
+			out: `<ul>
+<li>a
+<ul>
+<li>
+<p>b</p>
+<p><span class="math display">\[x
+  y
+\]</span></p>
+</li>
+</ul>
+</li>
+</ul>`,
+		},
+		{
+			d:  "fence indented within the sublist item",
+			in: "- a\n  - b\n\n      $$\n        x\n       y\n      $$",
+			out: `<ul>
+<li>a
+<ul>
+<li>
+<p>b</p>
+<p><span class="math display">\[  x
+ y
+\]</span></p>
+</li>
+</ul>
+</li>
+</ul>`,
+		},
+		{
+			d:  "content indented less than the fence",
+			in: "- a\n  - b\n\n     $$\n     x\n    y\n     $$",
+			out: `<ul>
+<li>a
+<ul>
+<li>
+<p>b</p>
+<p><span class="math display">\[x
+y
+\]</span></p>
+</li>
+</ul>
+</li>
+</ul>`,
+		},
+		{
+			d:  "sublist in a blockquote",
+			in: "> - a\n>   - b\n>\n>     $$\n>     x\n>       y\n>     $$",
+			out: `<blockquote>
+<ul>
+<li>a
+<ul>
+<li>
+<p>b</p>
+<p><span class="math display">\[x
+  y
+\]</span></p>
+</li>
+</ul>
+</li>
+</ul>
+</blockquote>`,
+		},
+	}, NewMathJax())
+}
+
+func TestFootnoteMath(t *testing.T) {
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:  "inline math",
+			in: "a[^1]\n\n[^1]: formula $x$",
+			out: `<p>a<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup></p>
+<section class="footnotes" role="doc-endnotes">
+<hr>
+<ol>
+<li id="fn:1" role="doc-endnote">
+<p>formula <span class="math inline">\(x\)</span> <a href="#fnref:1" class="footnote-backref" role="doc-backlink">&#x21a9;&#xfe0e;</a></p>
+</li>
+</ol>
+</section>`,
+		},
+		{
+			d:  "footnote reference syntax inside math",
+			in: "a[^1]\n\n[^1]: $b_1[^x]$",
+			out: `<p>a<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup></p>
+<section class="footnotes" role="doc-endnotes">
+<hr>
+<ol>
+<li id="fn:1" role="doc-endnote">
+<p><span class="math inline">\(b_1[^x]\)</span> <a href="#fnref:1" class="footnote-backref" role="doc-backlink">&#x21a9;&#xfe0e;</a></p>
+</li>
+</ol>
+</section>`,
+		},
+		{
+			d:  "display math opening the definition",
+			in: "a[^1]\n\n[^1]: $$\n    x\n    y\n    $$\nafter",
+			out: `<p>a<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup></p>
+<p>after</p>
+<section class="footnotes" role="doc-endnotes">
+<hr>
+<ol>
+<li id="fn:1" role="doc-endnote">
+<p><span class="math display">\[x
+y
+\]</span></p>
+ <a href="#fnref:1" class="footnote-backref" role="doc-backlink">&#x21a9;&#xfe0e;</a></li>
+</ol>
+</section>`,
+		},
+		{
+			d:  "display math in a later paragraph",
+			in: "a[^1]\n\n[^1]: text\n\n    $$y$$\n\n    more $z$",
+			out: `<p>a<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup></p>
+<section class="footnotes" role="doc-endnotes">
+<hr>
+<ol>
+<li id="fn:1" role="doc-endnote">
+<p>text</p>
+<p><span class="math display">\[y\]</span></p>
+<p>more <span class="math inline">\(z\)</span> <a href="#fnref:1" class="footnote-backref" role="doc-backlink">&#x21a9;&#xfe0e;</a></p>
+</li>
+</ol>
+</section>`,
+		},
+	}, MathJax, extension.Footnote)
+}
+
+func TestImageNoScriptFallback(t *testing.T) {
+	src := func(tex []byte) (string, error) {
+		if string(tex) == "bad" {
+			return "", errors.New("unsupported")
+		}
+		return "/math.svg?tex=" + url.QueryEscape(string(tex)), nil
+	}
+	runMathJaxTests(t, []mathJaxTestCase{
+		{
+			d:   "block",
+			in:  "$$\na<b\n$$",
+			out: "<p><span class=\"math display\">\\[a<b\n\\]<noscript><img src=\"/math.svg?tex=a%3Cb%0A\" alt=\"a&lt;b\n\"></noscript></span></p>",
+		},
+		{
+			d:   "same-line block",
+			in:  "$$x+y$$",
+			out: `<p><span class="math display">\[x+y\]<noscript><img src="/math.svg?tex=x%2By" alt="x+y"></noscript></span></p>`,
+		},
+		{
+			d:   "failed image",
+			in:  "$$bad$$",
+			out: `<p><span class="math display">\[bad\]</span></p>`,
+		},
+		{
+			d:   "inline math has no image",
+			in:  "$x$",
+			out: `<p><span class="math inline">\(x\)</span></p>`,
+		},
+	}, NewMathJax(WithImageNoScriptFallback(src)))
+}
+
+func TestUnterminatedBlockDiagnostic(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(MathJax))
+
+	pc := parser.NewContext()
+	var buf bytes.Buffer
+	if err := md.Convert([]byte("$$\nx\ny"), &buf, parser.WithContext(pc)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: "unterminated display math starting at line 1"},
+	}, Diagnostics(pc))
+
+	pc = parser.NewContext()
+	buf.Reset()
+	if err := md.Convert([]byte("text\n\n$$\nx\n$$\n\n$$\ny"), &buf, parser.WithContext(pc)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Diagnostic{
+		{Line: 7, Message: "unterminated display math starting at line 7"},
+	}, Diagnostics(pc))
+
+	pc = parser.NewContext()
+	buf.Reset()
+	if err := md.Convert([]byte("$$x$$\n\n$$\nx\n$$"), &buf, parser.WithContext(pc)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, Diagnostics(pc))
+}
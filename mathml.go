@@ -0,0 +1,151 @@
+package mathjax
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/util"
+)
+
+// MathMLConverter converts TeX to a MathML `<math>` element.
+type MathMLConverter interface {
+	ConvertMathML(tex []byte, display bool) ([]byte, error)
+}
+
+// MathMLConverterFunc adapts a function to a MathMLConverter.
+type MathMLConverterFunc func(tex []byte, display bool) ([]byte, error)
+
+// ConvertMathML calls f(tex, display).
+func (f MathMLConverterFunc) ConvertMathML(tex []byte, display bool) ([]byte, error) {
+	return f(tex, display)
+}
+
+type withMathMLFallback struct {
+	conv MathMLConverter
+}
+
+// WithMathMLFallback puts the MathML produced by conv in front of the TeX
+// inside the math span, so that browsers with native MathML support can
+// render it directly. Math the converter fails on is rendered as TeX only.
+func WithMathMLFallback(conv MathMLConverter) Option {
+	return &withMathMLFallback{conv}
+}
+
+func (o *withMathMLFallback) SetOption(e *mathjax) {
+	e.mathMLConverter = o.conv
+}
+
+// writeMathML writes the MathML for tex if a converter is configured and
+// succeeds.
+func writeMathML(w util.BufWriter, e *mathjax, tex []byte, display bool) {
+	if e.mathMLConverter == nil {
+		return
+	}
+	mathML, err := e.mathMLConverter.ConvertMathML(tex, display)
+	if err != nil {
+		return
+	}
+	if e.mathMLDisplayAttr {
+		mathML = setMathDisplay(mathML, display)
+	}
+	if e.texAnnotation {
+		mathML = addTeXAnnotation(mathML, tex)
+	}
+	_, _ = w.Write(mathML)
+}
+
+var (
+	mathStartTag      = []byte("<math")
+	mathDisplayAttr   = []byte(` display="`)
+	mathDisplayBlock  = []byte(` display="block"`)
+	mathDisplayInline = []byte(` display="inline"`)
+)
+
+// setMathDisplay sets the display attribute of the <math> element that
+// mathML starts with, replacing any value the converter gave it.
+func setMathDisplay(mathML []byte, display bool) []byte {
+	attr := mathDisplayInline
+	if display {
+		attr = mathDisplayBlock
+	}
+	trimmed := bytes.TrimLeft(mathML, " \t\r\n")
+	offset := len(mathML) - len(trimmed)
+	if !bytes.HasPrefix(trimmed, mathStartTag) {
+		return mathML
+	}
+	end := bytes.IndexByte(trimmed, '>')
+	if end < 0 {
+		return mathML
+	}
+	var buf bytes.Buffer
+	tag := trimmed[:end]
+	if i := bytes.Index(tag, mathDisplayAttr); i >= 0 {
+		j := bytes.IndexByte(tag[i+len(mathDisplayAttr):], '"')
+		if j < 0 {
+			return mathML
+		}
+		buf.Write(mathML[:offset+i])
+		buf.Write(attr)
+		buf.Write(mathML[offset+i+len(mathDisplayAttr)+j+1:])
+		return buf.Bytes()
+	}
+	buf.Write(mathML[:offset+len(mathStartTag)])
+	buf.Write(attr)
+	buf.Write(mathML[offset+len(mathStartTag):])
+	return buf.Bytes()
+}
+
+var mathEndTag = []byte("</math>")
+
+// addTeXAnnotation wraps the content of the <math> element of mathML in a
+// <semantics> element annotated with tex.
+func addTeXAnnotation(mathML, tex []byte) []byte {
+	start := bytes.Index(mathML, mathStartTag)
+	if start < 0 {
+		return mathML
+	}
+	open := bytes.IndexByte(mathML[start:], '>')
+	end := bytes.LastIndex(mathML, mathEndTag)
+	if open < 0 || end < start+open {
+		return mathML
+	}
+	open += start + 1
+	var buf bytes.Buffer
+	buf.Write(mathML[:open])
+	buf.WriteString("<semantics><mrow>")
+	buf.Write(mathML[open:end])
+	buf.WriteString(`</mrow><annotation encoding="application/x-tex">`)
+	buf.Write(util.EscapeHTML(tex))
+	buf.WriteString("</annotation></semantics>")
+	buf.Write(mathML[end:])
+	return buf.Bytes()
+}
+
+type withTeXAnnotation struct {
+	value bool
+}
+
+// WithTeXAnnotation adds the TeX to the MathML from the converter as an
+// `<annotation encoding="application/x-tex">` of a <semantics> element, so
+// that tools can recover the source.
+func WithTeXAnnotation(value bool) Option {
+	return &withTeXAnnotation{value}
+}
+
+func (o *withTeXAnnotation) SetOption(e *mathjax) {
+	e.texAnnotation = o.value
+}
+
+type withMathMLDisplayAttr struct {
+	value bool
+}
+
+// WithMathMLDisplayAttr sets the display attribute of the <math> element
+// from the converter to "block" for display math and "inline" for inline
+// math.
+func WithMathMLDisplayAttr(value bool) Option {
+	return &withMathMLDisplayAttr{value}
+}
+
+func (o *withMathMLDisplayAttr) SetOption(e *mathjax) {
+	e.mathMLDisplayAttr = o.value
+}
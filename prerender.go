@@ -0,0 +1,71 @@
+package mathjax
+
+import (
+	"github.com/yuin/goldmark/ast"
+)
+
+// TeXRenderer renders the TeX of a math node to HTML ahead of time.
+type TeXRenderer interface {
+	RenderTeX(tex []byte, display bool) ([]byte, error)
+}
+
+// PrerenderMath renders every math node under doc with r and replaces it
+// with an ast.String node holding the result, so that a plain HTML render
+// of doc writes the prerendered math. The nodes are replaced by code
+// strings, which are written verbatim, rather than ast.RawHTML, which can
+// only refer to source and is omitted without html.WithUnsafe. Display
+// blocks are replaced by an ast.TextBlock holding the string, which is
+// written without a paragraph, as their HTML may be block-level, such as
+// the `<div class="latex-svg display">` of TexRenderer. Every node is
+// rendered before any is replaced, so doc is left unchanged on error.
+// See (*mathjax).PrerenderMath.
+func PrerenderMath(doc ast.Node, source []byte, r TeXRenderer) error {
+	return MathJax.PrerenderMath(doc, source, r)
+}
+
+// PrerenderMath is like the function of the same name, but the TeX passed
+// to r is the TeX the renderers of e would write, e.g. filtered by
+// WithCommandAllowlist and WithCommandDenylist.
+func (e *mathjax) PrerenderMath(doc ast.Node, source []byte, r TeXRenderer) error {
+	var nodes []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n.(type) {
+		case *MathBlock, *InlineMath:
+			nodes = append(nodes, n)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	replacements := make([]ast.Node, len(nodes))
+	for i, n := range nodes {
+		var tex []byte
+		display := true
+		switch m := n.(type) {
+		case *MathBlock:
+			tex = e.blockMathTeX(source, m)
+		case *InlineMath:
+			tex = e.inlineMathTeX(source, m)
+			display = m.Display
+		}
+		html, err := r.RenderTeX(tex, display)
+		if err != nil {
+			return err
+		}
+		s := ast.NewString(html)
+		s.SetCode(true)
+		replacements[i] = s
+		if n.Type() == ast.TypeBlock {
+			b := ast.NewTextBlock()
+			b.SetBlankPreviousLines(n.HasBlankPreviousLines())
+			b.AppendChild(b, s)
+			replacements[i] = b
+		}
+	}
+	for i, n := range nodes {
+		n.Parent().ReplaceChild(n.Parent(), n, replacements[i])
+	}
+	return nil
+}
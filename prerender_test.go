@@ -0,0 +1,103 @@
+package mathjax
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+type stubTeXRenderer struct {
+	calls int
+}
+
+func (r *stubTeXRenderer) RenderTeX(tex []byte, display bool) ([]byte, error) {
+	r.calls++
+	if string(tex) == "bad" {
+		return nil, errors.New("bad TeX")
+	}
+	if display {
+		return []byte(`<svg class="display">` + string(tex) + `</svg>`), nil
+	}
+	return []byte(`<svg>` + string(tex) + `</svg>`), nil
+}
+
+func TestPrerenderMath(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(MathJax))
+	source := []byte("a $x$ b\n\n$$\ny\n$$\n\n- $z$\n")
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	r := &stubTeXRenderer{}
+	if err := PrerenderMath(doc, source, r); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, r.calls)
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		assert.NotEqual(t, KindMathBlock, n.Kind())
+		assert.NotEqual(t, KindInlineMath, n.Kind())
+		return ast.WalkContinue, nil
+	})
+	assert.Equal(t, ast.KindString, doc.FirstChild().FirstChild().NextSibling().Kind())
+	assert.Equal(t, ast.KindTextBlock, doc.FirstChild().NextSibling().Kind())
+
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `<p>a <svg>x</svg> b</p>
+<svg class="display">y
+</svg>
+<ul>
+<li><svg>z</svg></li>
+</ul>`, strings.TrimSpace(buf.String()))
+}
+
+func TestPrerenderMathTexRenderer(t *testing.T) {
+	for _, cmd := range []string{"pdflatex", "pdf2svg"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("%s is not installed", cmd)
+		}
+	}
+	md := goldmark.New(goldmark.WithExtensions(MathJax))
+	source := []byte("a\n\n$$x$$\n\nb\n")
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	if err := PrerenderMath(doc, source, NewDefaultTexRenderer()); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, buf.String(), "</p>\n<div class=\"latex-svg display\">")
+	assert.NotContains(t, buf.String(), "<p><div")
+}
+
+func TestPrerenderMathError(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(MathJax))
+	source := []byte("$a$ $bad$ $c$")
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	r := &stubTeXRenderer{}
+	assert.EqualError(t, PrerenderMath(doc, source, r), "bad TeX")
+	assert.Equal(t, 2, r.calls)
+	assert.Equal(t, KindInlineMath, doc.FirstChild().FirstChild().Kind())
+	assert.Equal(t, KindInlineMath, doc.FirstChild().LastChild().Kind())
+}
+
+func TestPrerenderMathOptions(t *testing.T) {
+	e := NewMathJax(WithStripZeroWidth(true), WithStripTeXComments(true))
+	md := goldmark.New(goldmark.WithExtensions(e))
+	source := []byte("$a\u200b$\n\n$$\nb % note\n$$\n")
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	r := &recordingTeXRenderer{}
+	assert.NoError(t, e.PrerenderMath(doc, source, r))
+	assert.Equal(t, []string{"a", "b\n"}, r.tex)
+}
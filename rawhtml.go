@@ -0,0 +1,64 @@
+package mathjax
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+type withMathInRawHTML struct {
+	value bool
+}
+
+// WithMathInRawHTML renders the math in the text of raw HTML blocks, which
+// are otherwise written as they are. Raw HTML is only written with
+// html.WithUnsafe.
+func WithMathInRawHTML(value bool) Option {
+	return &withMathInRawHTML{value}
+}
+
+func (o *withMathInRawHTML) SetOption(e *mathjax) {
+	e.mathInRawHTML = o.value
+}
+
+// rawHTMLRenderer replaces the renderer of raw HTML blocks to render the
+// math in them.
+type rawHTMLRenderer struct {
+	html.Config
+	config *mathjax
+}
+
+func (r *rawHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindHTMLBlock, r.renderHTMLBlock)
+}
+
+func (r *rawHTMLRenderer) renderHTMLBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.HTMLBlock)
+	if !r.Unsafe {
+		if entering || n.HasClosure() {
+			_, _ = w.WriteString("<!-- raw HTML omitted -->\n")
+		}
+		return ast.WalkContinue, nil
+	}
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var buf bytes.Buffer
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		buf.Write(line.Value(source))
+	}
+	if n.HasClosure() {
+		buf.Write(n.ClosureLine.Value(source))
+	}
+	out, err := r.config.RenderMathInHTML(buf.Bytes())
+	if err != nil {
+		// e.g. a tag continued past the block; leave it alone
+		out = buf.Bytes()
+	}
+	_, _ = w.Write(out)
+	return ast.WalkContinue, nil
+}
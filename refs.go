@@ -0,0 +1,84 @@
+package mathjax
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+)
+
+type withRefChecking struct {
+	value bool
+}
+
+// WithRefChecking records a diagnostic for every `\ref{...}` or
+// `\eqref{...}` in the math of a document whose label no `\label{...}` in
+// the document defines.
+func WithRefChecking(value bool) Option {
+	return &withRefChecking{value}
+}
+
+func (o *withRefChecking) SetOption(e *mathjax) {
+	e.refChecking = o.value
+}
+
+var refCommands = [][]byte{[]byte(`\ref{`), []byte(`\eqref{`)}
+
+// commandArguments returns the arguments of every occurrence of cmd, a
+// command including its opening brace, in tex.
+func commandArguments(tex, cmd []byte) [][]byte {
+	var args [][]byte
+	for {
+		i := bytes.Index(tex, cmd)
+		if i < 0 {
+			return args
+		}
+		tex = tex[i+len(cmd):]
+		end := bytes.IndexByte(tex, '}')
+		if end < 0 {
+			return args
+		}
+		args = append(args, bytes.TrimSpace(tex[:end]))
+		tex = tex[end+1:]
+	}
+}
+
+// checkReferences adds a diagnostic to pc for every reference in the math
+// of doc to a label that is not defined in doc.
+func checkReferences(doc *ast.Document, source []byte, pc parser.Context) {
+	type reference struct {
+		label []byte
+		line  int
+	}
+	labels := map[string]bool{}
+	var refs []reference
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var tex []byte
+		var line int
+		switch m := n.(type) {
+		case *MathBlock:
+			tex, line = blockTeX(source, m), m.Line
+		case *InlineMath:
+			tex, line = inlineTeX(source, m), m.Line
+		default:
+			return ast.WalkContinue, nil
+		}
+		for _, label := range commandArguments(tex, labelCommand) {
+			labels[string(label)] = true
+		}
+		for _, cmd := range refCommands {
+			for _, label := range commandArguments(tex, cmd) {
+				refs = append(refs, reference{label, line})
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	for _, ref := range refs {
+		if !labels[string(ref.label)] {
+			addDiagnostic(pc, ref.line, "unresolved reference %q at line %d", ref.label, ref.line)
+		}
+	}
+}
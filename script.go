@@ -0,0 +1,39 @@
+package mathjax
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/util"
+)
+
+var scriptEndTag = []byte("</")
+
+// writeScript writes tex in a <script> element of the given type, with a
+// nonce attribute if nonce is set. Script content is not HTML-decoded, so
+// only `</` needs escaping to keep the TeX from closing the element.
+func writeScript(w util.BufWriter, nonce func() string, typ string, tex []byte) {
+	_, _ = w.WriteString(`<script type="` + typ + `"`)
+	if nonce != nil {
+		_, _ = w.WriteString(` nonce="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(nonce())))
+		_, _ = w.WriteString(`"`)
+	}
+	_, _ = w.WriteString(`>`)
+	_, _ = w.Write(bytes.ReplaceAll(tex, scriptEndTag, []byte(`<\/`)))
+	_, _ = w.WriteString(`</script>`)
+}
+
+type withScriptNonce struct {
+	nonce func() string
+}
+
+// WithScriptNonce adds a nonce attribute with the value returned by nonce,
+// called once per element, to the script elements written by
+// WithMathJax3Containers, for use with a Content-Security-Policy.
+func WithScriptNonce(nonce func() string) Option {
+	return &withScriptNonce{nonce}
+}
+
+func (o *withScriptNonce) SetOption(e *mathjax) {
+	e.scriptNonce = o.nonce
+}
@@ -0,0 +1,98 @@
+package mathjax
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+type withStrict struct {
+	value bool
+}
+
+// WithStrict makes Convert fail on unterminated math instead of rendering
+// it as text. The error is a *ParseError describing the first problem
+// found; display blocks are parsed before inline math.
+func WithStrict(value bool) Option {
+	return &withStrict{value}
+}
+
+func (o *withStrict) SetOption(e *mathjax) {
+	e.strict = o.value
+}
+
+// ParseError describes the unterminated math that made Convert fail in
+// strict mode.
+type ParseError struct {
+	// Line and Column are the 1-based position of the opening delimiter;
+	// Column counts bytes.
+	Line   int
+	Column int
+
+	// Kind is "inline" or "block".
+	Kind string
+
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("mathjax: %d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+var parseErrorsKey = parser.NewContextKey()
+
+func addParseError(pc parser.Context, e *ParseError) {
+	errs, _ := pc.Get(parseErrorsKey).([]*ParseError)
+	pc.Set(parseErrorsKey, append(errs, e))
+}
+
+// sourceColumn returns the 1-based byte column of offset in source.
+func sourceColumn(source []byte, offset int) int {
+	return offset - bytes.LastIndexByte(source[:offset], '\n')
+}
+
+// strictErrorNode carries a strict mode error from the parser to the
+// renderer, which is the only place Convert can fail.
+type strictErrorNode struct {
+	ast.BaseBlock
+	err error
+}
+
+var kindStrictError = ast.NewNodeKind("MathJaxStrictError")
+
+func (n *strictErrorNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Error": n.err.Error()}, nil)
+}
+
+func (n *strictErrorNode) Kind() ast.NodeKind {
+	return kindStrictError
+}
+
+// insertStrictError puts a node failing with the first parse error recorded
+// in pc, if any, in front of the document, so that nothing is rendered.
+func insertStrictError(doc *ast.Document, pc parser.Context) {
+	errs, _ := pc.Get(parseErrorsKey).([]*ParseError)
+	if len(errs) == 0 {
+		return
+	}
+	n := &strictErrorNode{err: errs[0]}
+	if doc.FirstChild() == nil {
+		doc.AppendChild(doc, n)
+	} else {
+		doc.InsertBefore(doc, doc.FirstChild(), n)
+	}
+}
+
+type strictErrorRenderer struct{}
+
+func (r *strictErrorRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindStrictError, r.renderStrictError)
+}
+
+func (r *strictErrorRenderer) renderStrictError(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	return ast.WalkStop, node.(*strictErrorNode).err
+}
@@ -0,0 +1,67 @@
+package mathjax
+
+import (
+	"text/template"
+
+	"github.com/yuin/goldmark/util"
+)
+
+// TemplateData is the value passed to the template given to WithTemplate.
+type TemplateData struct {
+	// TeX is the raw math content, without delimiters and not escaped.
+	TeX string
+	// Display is true for display math and false for inline math.
+	Display bool
+	// Line is the 1-based source line the math starts on.
+	Line int
+	// Index is the position of the math among all math nodes of the
+	// document.
+	Index int
+}
+
+type withTemplate struct {
+	tmpl *template.Template
+}
+
+// WithTemplate renders every math node by executing tmpl with a
+// TemplateData, replacing the default markup entirely.
+// The TeX is passed unescaped; use the `html` function where needed.
+// RenderMathInHTML passes it already escaped.
+func WithTemplate(tmpl *template.Template) Option {
+	return &withTemplate{tmpl}
+}
+
+func (o *withTemplate) SetOption(e *mathjax) {
+	e.template = o.tmpl
+}
+
+func executeTemplate(w util.BufWriter, tmpl *template.Template, tex []byte, display bool, line, index int) error {
+	return tmpl.Execute(w, TemplateData{
+		TeX:     string(tex),
+		Display: display,
+		Line:    line,
+		Index:   index,
+	})
+}
+
+type withTemplateElement struct {
+	name string
+}
+
+// WithTemplateElement renders math as a custom element that reads the TeX
+// from a nested <template>, as in
+// `<math-tex><template>\(x\)</template></math-tex>` for name "math-tex".
+// The TeX is HTML-escaped.
+func WithTemplateElement(name string) Option {
+	return &withTemplateElement{name}
+}
+
+func (o *withTemplateElement) SetOption(e *mathjax) {
+	e.templateElement = o.name
+}
+
+func writeTemplateElement(w util.BufWriter, name, start string, tex []byte, end string) {
+	_, _ = w.WriteString(`<` + name + `><template>` + start)
+	_, _ = w.Write(util.EscapeHTML(tex))
+	_, _ = w.WriteString(end + `</template></` + name + `>`)
+}
@@ -2,6 +2,8 @@ package mathjax
 
 import (
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -122,6 +124,25 @@ func (r *TexRenderer) Run(formula string) []byte {
 	return r.runRaw(fmt.Sprintf(tmpl, strings.TrimSpace(formula)))
 }
 
+// RenderTeX renders tex to an embedded SVG image, implementing
+// TeXRenderer.
+func (r *TexRenderer) RenderTeX(tex []byte, display bool) ([]byte, error) {
+	var svg []byte
+	if display {
+		svg = r.Run(string(tex))
+	} else {
+		svg = r.RunInline(string(tex))
+	}
+	if svg == nil {
+		return nil, errors.New("mathjax: TeX rendering failed")
+	}
+	embed := `<embed alt="" src="data:image/svg+xml;base64, ` + base64.StdEncoding.EncodeToString(svg) + `"></embed>`
+	if display {
+		return []byte(`<div class="latex-svg display">` + embed + `</div>`), nil
+	}
+	return []byte(`<span class="latex-svg inline">` + embed + `</span>`), nil
+}
+
 func (r *TexRenderer) runRaw(formula string) []byte {
 	f, err := ioutil.TempFile(r.tmpDir, "doc")
 	if err != nil {
@@ -0,0 +1,156 @@
+package mathjax
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+type mathTransformer struct {
+	config *mathjax
+}
+
+func (t *mathTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	pc.Set(htmlEscapeKey, t.config.escapesTeX())
+	if t.config.literalFence != "" {
+		t.replaceLiteralFences(doc, reader.Source())
+	}
+	if t.config.mathFence != "" {
+		t.replaceMathFences(doc, reader.Source(), pc)
+	}
+	if t.config.mergeAdjacentDisplay {
+		sep := t.config.mergeSeparator
+		if sep == "" {
+			sep = `\\`
+		}
+		mergeAdjacentDisplay(doc, reader.Source(), sep)
+	}
+	if t.config.refChecking {
+		checkReferences(doc, reader.Source(), pc)
+	}
+	if t.config.strict {
+		insertStrictError(doc, pc)
+	}
+	index := 0
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch m := n.(type) {
+		case *MathBlock:
+			m.Index = index
+			index++
+		case *InlineMath:
+			m.Index = index
+			index++
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// replaceLiteralFences turns fenced code blocks tagged with the configured
+// literal fence language into TeXLiteralBlock nodes.
+func (t *mathTransformer) replaceLiteralFences(doc *ast.Document, source []byte) {
+	var fences []*ast.FencedCodeBlock
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if f, ok := n.(*ast.FencedCodeBlock); ok && entering {
+			if string(f.Language(source)) == t.config.literalFence {
+				fences = append(fences, f)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, f := range fences {
+		literal := NewTeXLiteralBlock()
+		literal.SetLines(f.Lines())
+		f.Parent().ReplaceChild(f.Parent(), f, literal)
+	}
+}
+
+// replaceMathFences turns fenced code blocks tagged with the configured math
+// fence language into MathBlock nodes.
+func (t *mathTransformer) replaceMathFences(doc *ast.Document, source []byte, pc parser.Context) {
+	var fences []*ast.FencedCodeBlock
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if f, ok := n.(*ast.FencedCodeBlock); ok && entering {
+			if string(f.Language(source)) == t.config.mathFence {
+				fences = append(fences, f)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, f := range fences {
+		// over the node cap, the fence stays a plain code block
+		if !t.config.takeMathNode(pc) {
+			break
+		}
+		m := NewMathBlock()
+		m.SetLines(f.Lines())
+		m.SetBlankPreviousLines(f.HasBlankPreviousLines())
+		// the opening fence precedes the info string on its line
+		info := f.Info.Segment.Start
+		fence := info
+		for fence > 0 && (source[fence-1] == ' ' || source[fence-1] == '\t') {
+			fence--
+		}
+		end := fence
+		for fence > 0 && (source[fence-1] == '`' || source[fence-1] == '~') {
+			fence--
+		}
+		m.Line = sourceLine(pc, source, fence)
+		m.delim = string(source[fence:end])
+		f.Parent().ReplaceChild(f.Parent(), f, m)
+	}
+}
+
+// mergeAdjacentDisplay merges runs of display blocks that are not separated
+// by blank lines into the first block of each run, as the rows of an aligned
+// environment separated by sep. Definition blocks are never merged.
+func mergeAdjacentDisplay(doc *ast.Document, source []byte, sep string) {
+	var runs [][]*MathBlock
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		first, ok := n.(*MathBlock)
+		if !entering || !ok || first.Definition {
+			return ast.WalkContinue, nil
+		}
+		if prev, ok := n.PreviousSibling().(*MathBlock); ok && !prev.Definition && !n.HasBlankPreviousLines() {
+			return ast.WalkSkipChildren, nil // already part of a run
+		}
+		run := []*MathBlock{first}
+		for next := n.NextSibling(); next != nil && !next.HasBlankPreviousLines(); next = next.NextSibling() {
+			m, ok := next.(*MathBlock)
+			if !ok || m.Definition {
+				break
+			}
+			run = append(run, m)
+		}
+		if len(run) > 1 {
+			runs = append(runs, run)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	for _, run := range runs {
+		rows := make([][]byte, len(run))
+		for i, m := range run {
+			rows[i] = bytes.TrimSpace(blockTeX(source, m))
+		}
+		var buf bytes.Buffer
+		buf.WriteString("\\begin{aligned}\n")
+		// the separator has a line of its own, so that a `%` comment
+		// ending a row cannot swallow it
+		buf.Write(bytes.Join(rows, []byte("\n"+sep+"\n")))
+		buf.WriteString("\n\\end{aligned}")
+		first := run[0]
+		first.tex = buf.Bytes()
+		first.stop = run[len(run)-1].stop
+		for _, m := range run[1:] {
+			for i := 0; i < m.Lines().Len(); i++ {
+				first.Lines().Append(m.Lines().At(i))
+			}
+			m.Parent().RemoveChild(m.Parent(), m)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package mathjax
+
+import (
+	"errors"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	// ErrEmptyMath is returned when the math body contains only whitespace.
+	ErrEmptyMath = errors.New("mathjax: empty math")
+	// ErrUnclosedMath is returned when the closing delimiter is missing.
+	ErrUnclosedMath = errors.New("mathjax: no closing delimiter")
+	// ErrNotSingleMath is returned when the input is not exactly one math node.
+	ErrNotSingleMath = errors.New("mathjax: input is not a single math node")
+)
+
+var validationParser = goldmark.New(goldmark.WithExtensions(MathJax)).Parser()
+
+func (e *mathjax) parseForValidation(s string) (ast.Node, []byte, parser.Context) {
+	p := validationParser
+	if e != MathJax {
+		p = goldmark.New(goldmark.WithExtensions(e)).Parser()
+	}
+	src := []byte(s)
+	pc := parser.NewContext()
+	doc := p.Parse(text.NewReader(src), parser.WithContext(pc))
+	return doc, src, pc
+}
+
+// ValidateInline reports whether s parses as a single complete inline math
+// node such as `$x+y$`. See (*mathjax).ValidateInline.
+func ValidateInline(s string) error {
+	return MathJax.ValidateInline(s)
+}
+
+// ValidateInline reports whether s parses with the options of the extension
+// as a single complete inline math node. Math that is empty once filtered by
+// WithCommandAllowlist and WithCommandDenylist is ErrEmptyMath.
+func (e *mathjax) ValidateInline(s string) error {
+	doc, src, _ := e.parseForValidation(s)
+	p := doc.FirstChild()
+	if p == nil {
+		return ErrEmptyMath
+	}
+	if p.Kind() != ast.KindParagraph || p.NextSibling() != nil {
+		return ErrNotSingleMath
+	}
+	n, ok := p.FirstChild().(*InlineMath)
+	if !ok {
+		if t, ok := p.FirstChild().(*ast.Text); ok && len(t.Segment.Value(src)) > 0 && t.Segment.Value(src)[0] == '$' {
+			return ErrUnclosedMath
+		}
+		return ErrNotSingleMath
+	}
+	if n.NextSibling() != nil {
+		return ErrNotSingleMath
+	}
+	if util.IsBlank(e.filterCommands(inlineTeX(src, n))) {
+		return ErrEmptyMath
+	}
+	return nil
+}
+
+// ValidateBlock reports whether s parses as a single complete display math
+// block such as `$$x+y$$`. See (*mathjax).ValidateBlock.
+func ValidateBlock(s string) error {
+	return MathJax.ValidateBlock(s)
+}
+
+// ValidateBlock reports whether s parses with the options of the extension
+// as a single complete display math block. Math that is empty once filtered
+// by WithCommandAllowlist and WithCommandDenylist is ErrEmptyMath.
+func (e *mathjax) ValidateBlock(s string) error {
+	doc, src, pc := e.parseForValidation(s)
+	b := doc.FirstChild()
+	if b == nil {
+		return ErrEmptyMath
+	}
+	n, ok := b.(*MathBlock)
+	if !ok || n.NextSibling() != nil {
+		return ErrNotSingleMath
+	}
+	if len(Diagnostics(pc)) > 0 {
+		return ErrUnclosedMath
+	}
+	if util.IsBlank(e.filterCommands(blockTeX(src, n))) {
+		return ErrEmptyMath
+	}
+	return nil
+}
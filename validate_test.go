@@ -0,0 +1,53 @@
+package mathjax
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validateTestCase struct {
+	in  string
+	err error
+}
+
+func TestValidateInline(t *testing.T) {
+	tests := []validateTestCase{
+		{in: `$x$`, err: nil},
+		{in: `$\frac{1}{2}$`, err: nil},
+		{in: "$a\nb$", err: nil},
+		{in: `$x`, err: ErrUnclosedMath},
+		{in: `$  $`, err: ErrEmptyMath},
+		{in: ``, err: ErrEmptyMath},
+		{in: `x`, err: ErrNotSingleMath},
+		{in: `$x$ and more`, err: ErrNotSingleMath},
+		{in: `$x$ $y$`, err: ErrNotSingleMath},
+		{in: `$$x$$`, err: ErrNotSingleMath},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d: %q", i, tc.in), func(t *testing.T) {
+			assert.Equal(t, tc.err, ValidateInline(tc.in))
+		})
+	}
+}
+
+func TestValidateBlock(t *testing.T) {
+	tests := []validateTestCase{
+		{in: `$$x$$`, err: nil},
+		{in: "$$\nx\n$$", err: nil},
+		{in: "$$\\begin{pmatrix}\n1 & 2\n\\end{pmatrix}$$", err: nil},
+		{in: "$$\nx", err: ErrUnclosedMath},
+		{in: `$$$$`, err: ErrEmptyMath},
+		{in: "$$\n\n$$", err: ErrEmptyMath},
+		{in: ``, err: ErrEmptyMath},
+		{in: `$x$`, err: ErrNotSingleMath},
+		{in: "$$x$$\n$$y$$", err: ErrNotSingleMath},
+		{in: "$$x$$\ntext", err: ErrNotSingleMath},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d: %q", i, tc.in), func(t *testing.T) {
+			assert.Equal(t, tc.err, ValidateBlock(tc.in))
+		})
+	}
+}
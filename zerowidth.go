@@ -0,0 +1,76 @@
+package mathjax
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// defaultZeroWidthRunes are the invisible code points removed by
+// WithStripZeroWidth unless WithZeroWidthRunes replaces them.
+var defaultZeroWidthRunes = []rune{
+	'\u200b', // zero width space
+	'\u200c', // zero width non-joiner
+	'\u200d', // zero width joiner
+	'\u2060', // word joiner
+	'\ufeff', // zero width no-break space, BOM
+}
+
+type withStripZeroWidth struct {
+	value bool
+}
+
+// WithStripZeroWidth removes zero-width spaces, joiners and byte order marks,
+// which are easily pasted along with TeX, from the TeX of math nodes.
+func WithStripZeroWidth(value bool) Option {
+	return &withStripZeroWidth{value}
+}
+
+func (o *withStripZeroWidth) SetOption(e *mathjax) {
+	e.stripZeroWidth = o.value
+}
+
+type withZeroWidthRunes struct {
+	runes []rune
+}
+
+// WithZeroWidthRunes replaces the code points removed by WithStripZeroWidth.
+func WithZeroWidthRunes(runes []rune) Option {
+	return &withZeroWidthRunes{runes}
+}
+
+func (o *withZeroWidthRunes) SetOption(e *mathjax) {
+	e.zeroWidthRunes = o.runes
+}
+
+// stripZeroWidthRunes returns tex without the code points configured for
+// WithStripZeroWidth.
+func (e *mathjax) stripZeroWidthRunes(tex []byte) []byte {
+	if !e.stripZeroWidth {
+		return tex
+	}
+	runes := e.zeroWidthRunes
+	if runes == nil {
+		runes = defaultZeroWidthRunes
+	}
+	if bytes.IndexFunc(tex, func(r rune) bool { return containsRune(runes, r) }) < 0 {
+		return tex
+	}
+	buf := make([]byte, 0, len(tex))
+	for i := 0; i < len(tex); {
+		r, size := utf8.DecodeRune(tex[i:])
+		if !containsRune(runes, r) {
+			buf = append(buf, tex[i:i+size]...)
+		}
+		i += size
+	}
+	return buf
+}
+
+func containsRune(runes []rune, r rune) bool {
+	for _, c := range runes {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}